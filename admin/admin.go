@@ -23,33 +23,47 @@ var (
 	}
 )
 
-func IsAdmin(r *http.Request, log slog.Logger) bool {
+// Email returns the validated email address of the signed in user, or the
+// empty string if nobody is signed in for the OAuth 2.0 client clientID.
+func Email(r *http.Request, clientID string, log slog.Logger) string {
 	idtoken, err := r.Cookie("id_token")
 	if err != nil {
 		log.Infof("No cookie supplied.")
-		return false
+		return ""
 	}
 	resp, err := client.Get(fmt.Sprintf("https://www.googleapis.com/oauth2/v3/tokeninfo?%s", idtoken))
-	if err != nil || resp.StatusCode != 200 {
-		log.Infof("Failed to validate idtoken: %#v %s", *resp, err)
-		return false
+	if err != nil {
+		log.Infof("Failed to validate idtoken: %s", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		log.Infof("Failed to validate idtoken: status %d", resp.StatusCode)
+		return ""
 	}
 	claims := Claims{}
 	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
 		log.Infof("Failed to decode claims: %s", err)
-		return false
+		return ""
 	}
 	// Check if aud is correct.
-	if claims.Aud != config.CLIENT_ID {
+	if claims.Aud != clientID {
 		log.Infof("Wrong audience.")
-		return false
+		return ""
 	}
+	return claims.Mail
+}
 
-	for _, email := range config.ADMINS {
-		if email == claims.Mail {
-			return true
-		}
+// IsAdmin returns true if the request is signed in as one of the admins of
+// site.
+func IsAdmin(r *http.Request, clientID string, site *config.Site, log slog.Logger) bool {
+	email := Email(r, clientID, log)
+	if email == "" {
+		return false
+	}
+	if site.IsAdmin(email) {
+		return true
 	}
-	log.Infof("%q is not an administrator.", claims.Mail)
+	log.Infof("%q is not an administrator of %q.", email, site.Name)
 	return false
 }
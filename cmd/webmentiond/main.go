@@ -0,0 +1,136 @@
+// webmentiond serves all the Webmention handlers from a single HTTP server,
+// for running outside of Google Cloud Functions.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	webmention "github.com/jcgregorio/webmention-func"
+	"github.com/jcgregorio/webmention-func/config"
+	"github.com/jcgregorio/webmention-func/mention"
+	"github.com/jcgregorio/webmention-func/postgres"
+	"github.com/jcgregorio/webmention-func/sqlite"
+)
+
+var (
+	port            = flag.String("port", ":8080", "HTTP service address (e.g., ':8080')")
+	configFile      = flag.String("config", "", "The JSON config file. Values can be overridden by WEBMENTION_* environment variables.")
+	store           = flag.String("store", "sqlite", "The store to use: 'datastore', 'sqlite', 'postgres', or 'memory'.")
+	sqliteDir       = flag.String("sqlite_dir", ".", "The directory of the SQLite database files, one per site named <namespace>.db, if --store=sqlite.")
+	postgresURL     = flag.String("postgres_url", "", "The PostgreSQL connection URL, if --store=postgres. Each site is kept in the schema named by its namespace.")
+	verifyInterval  = flag.Duration("verify_interval", time.Minute, "How often to verify queued mentions.")
+	recrawlInterval = flag.Duration("recrawl_interval", time.Hour, "How often to schedule good mentions to be verified again.")
+	shutdownGrace   = flag.Duration("shutdown_grace", 30*time.Second, "How long to wait for in-flight requests on shutdown.")
+)
+
+func newStore(ctx context.Context, c *config.Config, site *config.Site) (mention.Store, error) {
+	switch *store {
+	case "datastore":
+		return mention.NewDatastoreStore(ctx, c.Project, site.Namespace)
+	case "sqlite":
+		return sqlite.New(filepath.Join(*sqliteDir, site.Namespace+".db"))
+	case "postgres":
+		return postgres.New(*postgresURL, site.Namespace)
+	case "memory":
+		return mention.NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("Unknown store: %q", *store)
+}
+
+func main() {
+	flag.Parse()
+	log := logger.New()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load config: %s", err)
+	}
+	stores := []mention.Store{}
+	mentions := map[string]*mention.Mentions{}
+	for _, site := range c.Sites {
+		s, err := newStore(ctx, c, site)
+		if err != nil {
+			log.Fatalf("Failed to create store for %q: %s", site.Name, err)
+		}
+		stores = append(stores, s)
+		mentions[site.Name] = mention.NewMentionsWithStore(s, log)
+	}
+	server, err := webmention.NewServer(c, mentions, log)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	server.AddHandlers(mux)
+	httpServer := &http.Server{
+		Addr:    *port,
+		Handler: mux,
+	}
+
+	// Verify queued mentions, and schedule recrawls, on tickers instead of
+	// from Cloud Scheduler.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(*verifyInterval)
+		defer ticker.Stop()
+		recrawl := time.NewTicker(*recrawlInterval)
+		defer recrawl.Stop()
+		for {
+			select {
+			case <-recrawl.C:
+				// The recrawled mentions are verified on the next tick.
+				server.ScheduleRecrawl(ctx)
+			case <-ticker.C:
+				// Finish before the next tick, so runs don't overlap.
+				verifyCtx, verifyCancel := context.WithTimeout(ctx, *verifyInterval)
+				server.VerifyQueuedMentions(verifyCtx)
+				verifyCancel()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Shut down gracefully on SIGTERM or SIGINT.
+	done := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+		sig := <-sigs
+		log.Infof("Received %s, shutting down.", sig)
+		cancel()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), *shutdownGrace)
+		defer shutdownCancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("Failed to shut down cleanly: %s", err)
+		}
+		close(done)
+	}()
+
+	log.Infof("Listening on %s", *port)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	wg.Wait()
+	for _, s := range stores {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Errorf("Failed to close store: %s", err)
+			}
+		}
+	}
+}
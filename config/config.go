@@ -1,15 +1,323 @@
+// config is a package for loading the runtime configuration.
+//
+// The configuration is read from a JSON file and then from environment
+// variables, which override the values in the file.
 package config
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
 
 const (
-	CLIENT_ID           = "952643138919-jh0117ivtbqkc9njoh91csm7s465c4na.apps.googleusercontent.com"
-	REGION              = "us-central1"
-	PROJECT             = "heroic-muse-88515"
-	DATASTORE_NAMESPACE = "blog"
+	// CONFIG_ENV is the environment variable that holds the name of the
+	// config file.
+	CONFIG_ENV = "WEBMENTION_CONFIG"
+
+	CLIENT_ID_ENV           = "WEBMENTION_CLIENT_ID"
+	REGION_ENV              = "WEBMENTION_REGION"
+	PROJECT_ENV             = "WEBMENTION_PROJECT"
+	DATASTORE_NAMESPACE_ENV = "WEBMENTION_DATASTORE_NAMESPACE"
+	HOST_ENV                = "WEBMENTION_HOST"
+	ADMINS_ENV              = "WEBMENTION_ADMINS"
+	DOMAIN_ENV              = "WEBMENTION_DOMAIN"
 )
 
-var (
-	HOST   = fmt.Sprintf("https://%s-%s.cloudfunctions.net", REGION, PROJECT)
-	ADMINS = []string{"joe.gregorio@gmail.com"}
+type Config struct {
+	// ClientID is the OAuth 2.0 client id used to sign in to the Triage page.
+	ClientID string `json:"client_id"`
+
+	// Region is the Google Cloud region the functions are deployed to.
+	Region string `json:"region"`
+
+	// Project is the Google Cloud project, i.e. "heroic-muse-88515".
+	Project string `json:"project"`
+
+	// DatastoreNamespace is the datastore namespace that data will be stored in.
+	DatastoreNamespace string `json:"datastore_namespace"`
+
+	// Host is the URL the handlers are served from. Defaults to the Cloud
+	// Functions URL for Region and Project.
+	Host string `json:"host"`
+
+	// Admins are the email addresses of the people allowed to triage.
+	//
+	// Only used if Sites is empty, see Sites.
+	Admins []string `json:"admins"`
+
+	// Domain is the hostname that the targets of Webmentions must have, i.e.
+	// "bitworking.org".
+	//
+	// Only used if Sites is empty, see Sites.
+	Domain string `json:"domain"`
+
+	// Sites are the sites that Webmentions are accepted for. If empty then
+	// there's a single site named DEFAULT_SITE made from Domain, Admins, and
+	// DatastoreNamespace.
+	Sites []*Site `json:"sites"`
+
+	// Limits are the caps on content fetched while verifying mentions.
+	Limits Limits `json:"limits"`
+
+	// URLPolicy is how URLs are normalized before they're compared.
+	URLPolicy URLPolicy `json:"url_policy"`
+
+	// RecrawlBudget is the most good mentions per site that are verified
+	// again in each recrawl. Zero uses the default.
+	RecrawlBudget int `json:"recrawl_budget"`
+
+	// RecrawlAfterHours is how many hours after a good mention was last
+	// verified that it may be verified again by a recrawl. Zero uses the
+	// default.
+	RecrawlAfterHours int `json:"recrawl_after_hours"`
+}
+
+// URLPolicy is how URLs are normalized before they're compared. By default
+// the differences below are ignored, and each can be kept instead.
+type URLPolicy struct {
+	// KeepScheme keeps http and https URLs distinct.
+	KeepScheme bool `json:"keep_scheme"`
+
+	// KeepWWW keeps hosts with and without a "www." prefix distinct.
+	KeepWWW bool `json:"keep_www"`
+
+	// KeepTrailingSlash keeps paths with and without a trailing slash
+	// distinct.
+	KeepTrailingSlash bool `json:"keep_trailing_slash"`
+
+	// KeepFragment keeps URLs that differ only in their fragment distinct.
+	KeepFragment bool `json:"keep_fragment"`
+}
+
+// NormalizeHost returns the normal form of the hostname host, the same as
+// mention.URLPolicy does, so that hosts are compared the way targets are.
+func (p URLPolicy) NormalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if !p.KeepWWW {
+		host = strings.TrimPrefix(host, "www.")
+	}
+	return host
+}
+
+// Limits are the caps on content fetched while verifying mentions. A zero
+// value leaves the default in place.
+type Limits struct {
+	// MaxSourceBytes is the largest source document that will be verified.
+	MaxSourceBytes int64 `json:"max_source_bytes"`
+
+	// SourceContentTypes are the media types a source may be served as, i.e.
+	// ["text/html"].
+	SourceContentTypes []string `json:"source_content_types"`
+
+	// MaxImageBytes is the largest author photo that will be read.
+	MaxImageBytes int64 `json:"max_image_bytes"`
+
+	// MaxImageWidth and MaxImageHeight are the largest dimensions of an
+	// author photo that will be decoded.
+	MaxImageWidth  int `json:"max_image_width"`
+	MaxImageHeight int `json:"max_image_height"`
+
+	// MaxImagePixels is the most pixels an author photo that will be decoded
+	// can have.
+	MaxImagePixels int `json:"max_image_pixels"`
+}
+
+// DEFAULT_SITE is the name of the site used when none are configured.
+const DEFAULT_SITE = "default"
+
+// Site is a site that accepts Webmentions. Each site's mentions are stored
+// separately and triaged separately.
+type Site struct {
+	// Name identifies the site in URLs, i.e. "blog".
+	Name string `json:"name"`
+
+	// Hosts are the hostnames that the targets of Webmentions for this site
+	// must have, i.e. ["bitworking.org", "www.bitworking.org"].
+	Hosts []string `json:"hosts"`
+
+	// Admins are the email addresses of the people allowed to triage this
+	// site's mentions.
+	Admins []string `json:"admins"`
+
+	// Namespace is the datastore namespace, or the tenant ID for other
+	// stores, that this site's mentions are stored in.
+	Namespace string `json:"namespace"`
+
+	// TargetCheck is how the targets of incoming mentions are checked to
+	// exist on the site: TARGET_CHECK_REQUEST, TARGET_CHECK_SITEMAP, or empty
+	// to not check them at all.
+	TargetCheck string `json:"target_check"`
+
+	// Sitemap is the URL of the site's sitemap, used by TARGET_CHECK_SITEMAP.
+	Sitemap string `json:"sitemap"`
+}
+
+const (
+	// TARGET_CHECK_REQUEST checks that a target exists by requesting it.
+	TARGET_CHECK_REQUEST = "request"
+
+	// TARGET_CHECK_SITEMAP checks that a target exists by looking it up in
+	// the site's sitemap.
+	TARGET_CHECK_SITEMAP = "sitemap"
 )
+
+// IsAdmin returns true if email belongs to one of the site's admins.
+func (s *Site) IsAdmin(email string) bool {
+	for _, admin := range s.Admins {
+		if admin == email {
+			return true
+		}
+	}
+	return false
+}
+
+// New returns a Config with all the defaults filled in.
+func New() *Config {
+	return &Config{
+		Region:             "us-central1",
+		DatastoreNamespace: "blog",
+		Admins:             []string{},
+	}
+}
+
+// Load reads the config from the JSON file filename, if filename isn't
+// empty, and then applies any overrides from environment variables.
+//
+// The returned Config has been validated.
+func Load(filename string) (*Config, error) {
+	c := New()
+	if filename != "" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open config file: %s", err)
+		}
+		defer f.Close()
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return nil, fmt.Errorf("Failed to parse config file %q: %s", filename, err)
+		}
+	}
+	c.applyEnv()
+	if c.Host == "" && c.Region != "" && c.Project != "" {
+		c.Host = fmt.Sprintf("https://%s-%s.cloudfunctions.net", c.Region, c.Project)
+	}
+	if len(c.Sites) == 0 && c.Domain != "" {
+		c.Sites = []*Site{
+			{
+				Name:      DEFAULT_SITE,
+				Hosts:     []string{c.Domain},
+				Admins:    c.Admins,
+				Namespace: c.DatastoreNamespace,
+			},
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFromEnv loads the config from the file named in the CONFIG_ENV
+// environment variable, along with any other environment variable overrides.
+func LoadFromEnv() (*Config, error) {
+	return Load(os.Getenv(CONFIG_ENV))
+}
+
+func (c *Config) applyEnv() {
+	for env, value := range map[string]*string{
+		CLIENT_ID_ENV:           &c.ClientID,
+		REGION_ENV:              &c.Region,
+		PROJECT_ENV:             &c.Project,
+		DATASTORE_NAMESPACE_ENV: &c.DatastoreNamespace,
+		HOST_ENV:                &c.Host,
+		DOMAIN_ENV:              &c.Domain,
+	} {
+		if s := os.Getenv(env); s != "" {
+			*value = s
+		}
+	}
+	if s := os.Getenv(ADMINS_ENV); s != "" {
+		c.Admins = []string{}
+		for _, email := range strings.Split(s, ",") {
+			if email = strings.TrimSpace(email); email != "" {
+				c.Admins = append(c.Admins, email)
+			}
+		}
+	}
+}
+
+// Validate returns an error if the config is incomplete or invalid.
+func (c *Config) Validate() error {
+	if c.ClientID == "" {
+		return fmt.Errorf("Config: client_id is required.")
+	}
+	if c.Host == "" {
+		return fmt.Errorf("Config: host is required, or region and project to derive it from.")
+	}
+	if !strings.HasPrefix(c.Host, "http://") && !strings.HasPrefix(c.Host, "https://") {
+		return fmt.Errorf("Config: host must be an http or https URL: %q", c.Host)
+	}
+	if len(c.Sites) == 0 {
+		return fmt.Errorf("Config: at least one site is required, or a domain to make one from.")
+	}
+	if c.Limits.MaxSourceBytes < 0 || c.Limits.MaxImageBytes < 0 || c.Limits.MaxImageWidth < 0 || c.Limits.MaxImageHeight < 0 || c.Limits.MaxImagePixels < 0 {
+		return fmt.Errorf("Config: limits can't be negative.")
+	}
+	if c.RecrawlBudget < 0 || c.RecrawlAfterHours < 0 {
+		return fmt.Errorf("Config: recrawl_budget and recrawl_after_hours can't be negative.")
+	}
+	names := map[string]bool{}
+	// hosts maps each normalized host to the site it belongs to.
+	hosts := map[string]string{}
+	namespaces := map[string]bool{}
+	for _, site := range c.Sites {
+		if site.Name == "" || strings.ContainsAny(site.Name, "/?&# ") {
+			return fmt.Errorf("Config: site name must be non-empty and usable in a URL: %q", site.Name)
+		}
+		if names[site.Name] {
+			return fmt.Errorf("Config: duplicate site name: %q", site.Name)
+		}
+		names[site.Name] = true
+		if len(site.Hosts) == 0 {
+			return fmt.Errorf("Config: site %q: domain or hosts is required.", site.Name)
+		}
+		for _, host := range site.Hosts {
+			if host == "" || strings.Contains(host, "/") {
+				return fmt.Errorf("Config: site %q: domain must be a hostname, not a URL: %q", site.Name, host)
+			}
+			normalized := c.URLPolicy.NormalizeHost(host)
+			if owner, ok := hosts[normalized]; ok && owner != site.Name {
+				return fmt.Errorf("Config: site %q: host belongs to more than one site: %q", site.Name, host)
+			}
+			hosts[normalized] = site.Name
+		}
+		if len(site.Admins) == 0 {
+			return fmt.Errorf("Config: site %q: at least one admin is required.", site.Name)
+		}
+		for _, email := range site.Admins {
+			if !strings.Contains(email, "@") {
+				return fmt.Errorf("Config: site %q: admin is not an email address: %q", site.Name, email)
+			}
+		}
+		if site.Namespace == "" {
+			return fmt.Errorf("Config: site %q: namespace is required.", site.Name)
+		}
+		switch site.TargetCheck {
+		case "", TARGET_CHECK_REQUEST:
+		case TARGET_CHECK_SITEMAP:
+			if !strings.HasPrefix(site.Sitemap, "http://") && !strings.HasPrefix(site.Sitemap, "https://") {
+				return fmt.Errorf("Config: site %q: sitemap must be an http or https URL: %q", site.Name, site.Sitemap)
+			}
+		default:
+			return fmt.Errorf("Config: site %q: unknown target_check: %q", site.Name, site.TargetCheck)
+		}
+		if namespaces[site.Namespace] {
+			return fmt.Errorf("Config: site %q: namespace is used by more than one site: %q", site.Name, site.Namespace)
+		}
+		namespaces[site.Namespace] = true
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	filename := filepath.Join(dir, "config.json")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	return filename, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	filename, cleanup := writeConfig(t, `{
+  "client_id": "abc.apps.googleusercontent.com",
+  "project": "my-project",
+  "admins": ["me@example.com"],
+  "domain": "example.com"
+}`)
+	defer cleanup()
+
+	c, err := Load(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc.apps.googleusercontent.com", c.ClientID)
+	assert.Equal(t, "blog", c.DatastoreNamespace)
+	assert.Equal(t, "https://us-central1-my-project.cloudfunctions.net", c.Host)
+	assert.Len(t, c.Sites, 1)
+	assert.Equal(t, DEFAULT_SITE, c.Sites[0].Name)
+	assert.Equal(t, "blog", c.Sites[0].Namespace)
+	assert.True(t, c.Sites[0].IsAdmin("me@example.com"))
+	assert.False(t, c.Sites[0].IsAdmin("you@example.com"))
+	assert.Equal(t, []string{"example.com"}, c.Sites[0].Hosts)
+
+	// Environment variables override the file.
+	os.Setenv(ADMINS_ENV, "you@example.com, them@example.com")
+	os.Setenv(HOST_ENV, "http://localhost:8080")
+	defer os.Unsetenv(ADMINS_ENV)
+	defer os.Unsetenv(HOST_ENV)
+	c, err = Load(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"you@example.com", "them@example.com"}, c.Sites[0].Admins)
+	assert.Equal(t, "http://localhost:8080", c.Host)
+}
+
+func TestLoadSites(t *testing.T) {
+	filename, cleanup := writeConfig(t, `{
+  "client_id": "abc.apps.googleusercontent.com",
+  "project": "my-project",
+  "sites": [
+    {
+      "name": "blog",
+      "hosts": ["example.com", "www.example.com"],
+      "admins": ["me@example.com"],
+      "namespace": "blog"
+    },
+    {
+      "name": "photos",
+      "hosts": ["photos.example.org"],
+      "admins": ["you@example.com"],
+      "namespace": "photos"
+    }
+  ]
+}`)
+	defer cleanup()
+
+	c, err := Load(filename)
+	assert.NoError(t, err)
+	assert.Len(t, c.Sites, 2)
+	assert.Equal(t, "blog", c.Sites[0].Name)
+	assert.Equal(t, []string{"example.com", "www.example.com"}, c.Sites[0].Hosts)
+	assert.Equal(t, "photos", c.Sites[1].Name)
+	assert.Equal(t, []string{"photos.example.org"}, c.Sites[1].Hosts)
+	assert.False(t, c.Sites[1].IsAdmin("me@example.com"))
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		value   string
+		message string
+	}{
+		{
+			value:   `{"client_id": "abc", "project": "p", "admins": ["me@example.com"], "domain": "example.com", "extra": 1}`,
+			message: "unknown field",
+		},
+		{
+			value:   `{"project": "p", "admins": ["me@example.com"], "domain": "example.com"}`,
+			message: "client_id is required",
+		},
+		{
+			value:   `{"client_id": "abc", "admins": ["me@example.com"], "domain": "example.com"}`,
+			message: "host is required",
+		},
+		{
+			value:   `{"client_id": "abc", "host": "localhost", "admins": ["me@example.com"], "domain": "example.com"}`,
+			message: "http or https",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "admins": ["me@example.com"]}`,
+			message: "at least one site is required",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "admins": ["me@example.com"], "domain": "https://example.com/"}`,
+			message: "must be a hostname",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "domain": "example.com"}`,
+			message: "at least one admin",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "admins": ["me"], "domain": "example.com"}`,
+			message: "not an email address",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "sites": [{"name": "a", "hosts": ["example.com"], "admins": ["me@example.com"], "namespace": "a"}, {"name": "a", "hosts": ["example.org"], "admins": ["me@example.com"], "namespace": "b"}]}`,
+			message: "duplicate site name",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "sites": [{"name": "a", "hosts": ["example.com"], "admins": ["me@example.com"], "namespace": "a", "target_check": "ping"}]}`,
+			message: "unknown target_check",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "sites": [{"name": "a", "hosts": ["example.com"], "admins": ["me@example.com"], "namespace": "a", "target_check": "sitemap"}]}`,
+			message: "sitemap must be an http or https URL",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "limits": {"max_source_bytes": -1}, "admins": ["me@example.com"], "domain": "example.com"}`,
+			message: "limits can't be negative",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "recrawl_budget": -1, "admins": ["me@example.com"], "domain": "example.com"}`,
+			message: "recrawl_after_hours can't be negative",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "sites": [{"name": "a", "hosts": ["example.com"], "admins": ["me@example.com"], "namespace": "a"}, {"name": "b", "hosts": ["Example.com"], "admins": ["me@example.com"], "namespace": "b"}]}`,
+			message: "more than one site",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "sites": [{"name": "a", "hosts": ["example.com"], "admins": ["me@example.com"], "namespace": "a"}, {"name": "b", "hosts": ["www.example.com"], "admins": ["me@example.com"], "namespace": "b"}]}`,
+			message: "more than one site",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "sites": [{"name": "a", "hosts": ["example.com"], "admins": ["me@example.com"], "namespace": "a"}, {"name": "b", "hosts": ["example.org"], "admins": ["me@example.com"], "namespace": "a"}]}`,
+			message: "namespace is used by more than one site",
+		},
+		{
+			value:   `{"client_id": "abc", "project": "p", "sites": [{"name": "a", "admins": ["me@example.com"], "namespace": "a"}]}`,
+			message: "hosts is required",
+		},
+	}
+	for _, tc := range testCases {
+		filename, cleanup := writeConfig(t, tc.value)
+		_, err := Load(filename)
+		cleanup()
+		if assert.Error(t, err, tc.value) {
+			assert.Contains(t, err.Error(), tc.message)
+		}
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	_, err := Load("/no/such/config.json")
+	assert.Error(t, err)
+}
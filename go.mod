@@ -8,8 +8,11 @@ require (
 	github.com/jcgregorio/logger v0.0.0-20190327205355-c696fa1d0bdc
 	github.com/jcgregorio/slog v0.0.0-20190327203141-011247cf2291
 	github.com/kylelemons/godebug v0.0.0-20170820004349-d65d576e9348 // indirect
+	github.com/lib/pq v1.0.0
+	github.com/mattn/go-sqlite3 v1.10.0
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/stretchr/testify v1.3.0
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a
 	google.golang.org/api v0.3.0
 	willnorris.com/go/microformats v1.0.0
 	willnorris.com/go/webmention v0.0.0-20180916134737-ea952590cf48
@@ -0,0 +1,292 @@
+package mention
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"image/png"
+	"net/url"
+	"time"
+
+	"github.com/nfnt/resize"
+	"willnorris.com/go/microformats"
+)
+
+// DEFAULT_AUTHOR_CACHE_DURATION is the default for
+// Mentions.AuthorCacheDuration.
+const DEFAULT_AUTHOR_CACHE_DURATION = 24 * time.Hour
+
+// AuthorProfile is what was found out about an author from their page, or
+// from the h-card in one of their posts. Profiles are cached by the URL of
+// the author's page until they expire.
+type AuthorProfile struct {
+	URL       string    `datastore:",noindex"`
+	Name      string    `datastore:",noindex"`
+	Photo     string    `datastore:",noindex"`
+	Thumbnail string    `datastore:",noindex"`
+	Expires   time.Time `datastore:",noindex"`
+}
+
+// findAuthor sets the author of mention from the h-entry it, using the
+// authorship algorithm, https://indieweb.org/authorship-spec:
+//
+//  1. The author of the h-entry, or failing that of the h-feed it's in, or
+//     failing that the first rel=author link on the page.
+//  2. If that's an h-card then it's the author.
+//  3. If it's a URL then it's the author's page, which is fetched, and its
+//     representative h-card is the author.
+//  4. Otherwise it's the author's name.
+func (m *Mentions) findAuthor(ctx context.Context, u2r UrlToImageReader, mention *Mention, data *microformats.Data, it, feed *microformats.Microformat) {
+	authors := it.Properties["author"]
+	if len(authors) == 0 && feed != nil {
+		authors = feed.Properties["author"]
+	}
+	var author interface{}
+	if len(authors) > 0 {
+		author = authors[0]
+	} else if rels := data.Rels["author"]; len(rels) > 0 {
+		author = rels[0]
+	} else {
+		return
+	}
+
+	switch author := author.(type) {
+	case *microformats.Microformat:
+		if in("h-card", author.Type) {
+			// An h-card with a photo doesn't get an implied url, so the page's
+			// rel=author is the next best thing.
+			authorURL := ""
+			if rels := data.Rels["author"]; len(rels) > 0 {
+				authorURL = rels[0]
+			}
+			m.setAuthor(ctx, u2r, mention, author, authorURL)
+			return
+		}
+		// An embedded h-entry or similar, whose value is the author's URL or
+		// name.
+		m.findAuthorByValue(ctx, u2r, mention, author.Value)
+	case string:
+		m.findAuthorByValue(ctx, u2r, mention, author)
+	}
+}
+
+// findAuthorByValue sets the author of mention from value, which is either
+// the URL of the author's page, or their name.
+func (m *Mentions) findAuthorByValue(ctx context.Context, u2r UrlToImageReader, mention *Mention, value string) {
+	if !isAbsoluteURL(value) {
+		mention.Author = value
+		return
+	}
+	profile := m.authorProfile(ctx, value)
+	if profile == nil {
+		profile = &AuthorProfile{
+			URL: value,
+		}
+		card, err := m.representativeCard(u2r, value)
+		if err != nil {
+			m.log.Infof("No author found at %q: %s", value, err)
+		} else {
+			profile.Name = cardName(card)
+			if u := firstPropAsString(card, "url"); u != "" {
+				profile.URL = u
+			}
+			profile.Photo = firstPropAsString(card, "photo")
+			if profile.Photo != "" {
+				profile.Thumbnail = m.thumbnail(ctx, u2r, profile.Photo)
+			}
+		}
+		// Pages without an h-card are cached too, so that they aren't
+		// fetched for every mention.
+		m.putAuthorProfile(ctx, value, profile)
+	}
+	mention.Author = profile.Name
+	mention.AuthorURL = profile.URL
+	mention.Thumbnail = profile.Thumbnail
+}
+
+// representativeCard fetches the author page at u, and returns its
+// representative h-card, http://microformats.org/wiki/representative-h-card-parsing,
+// which is the first of:
+//
+//  1. An h-card whose url and uid are both u.
+//  2. An h-card whose url is one of the page's rel=me links.
+//  3. The only h-card on the page, if its url is u.
+func (m *Mentions) representativeCard(u2r UrlToImageReader, u string) (*microformats.Microformat, error) {
+	base, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid author URL: %s", err)
+	}
+	r, err := u2r(u)
+	if err != nil {
+		return nil, err
+	}
+	defer m.close(r)
+	b, err := readLimited(r, m.MaxSourceBytes, "Author page")
+	if err != nil {
+		return nil, err
+	}
+	data := microformats.Parse(bytes.NewReader(b), base)
+	cards := hCards(data.Items)
+	hasURL := func(card *microformats.Microformat, key string, urls []string) bool {
+		for _, v := range card.Properties[key] {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			for _, u := range urls {
+				if m.URLPolicy.Equal(s, u) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, card := range cards {
+		if hasURL(card, "url", []string{u}) && hasURL(card, "uid", []string{u}) {
+			return card, nil
+		}
+	}
+	if me := data.Rels["me"]; len(me) > 0 {
+		for _, card := range cards {
+			if hasURL(card, "url", me) {
+				return card, nil
+			}
+		}
+	}
+	if len(cards) == 1 && hasURL(cards[0], "url", []string{u}) {
+		return cards[0], nil
+	}
+	return nil, fmt.Errorf("No representative h-card.")
+}
+
+// hCards returns all the h-cards in items, and their children, in document
+// order.
+func hCards(items []*microformats.Microformat) []*microformats.Microformat {
+	ret := []*microformats.Microformat{}
+	for _, it := range items {
+		if in("h-card", it.Type) {
+			ret = append(ret, it)
+		}
+		ret = append(ret, hCards(it.Children)...)
+	}
+	return ret
+}
+
+// cardName returns the name in the h-card card.
+func cardName(card *microformats.Microformat) string {
+	if name := firstPropAsString(card, "name"); name != "" {
+		return name
+	}
+	if !isAbsoluteURL(card.Value) {
+		return card.Value
+	}
+	return ""
+}
+
+// setAuthor sets the author of mention from the h-card card, with authorURL
+// as the author's URL if the h-card doesn't have one, and a thumbnail of
+// their photo.
+//
+// The thumbnail is taken from the author's cached profile if the photo hasn't
+// changed, so that it isn't fetched and resized for every mention.
+func (m *Mentions) setAuthor(ctx context.Context, u2r UrlToImageReader, mention *Mention, card *microformats.Microformat, authorURL string) {
+	mention.Author = cardName(card)
+	mention.AuthorURL = authorURL
+	if u := firstPropAsString(card, "url"); u != "" {
+		mention.AuthorURL = u
+	}
+	photo := firstPropAsString(card, "photo")
+	if photo == "" {
+		m.log.Infof("No photo URL found.")
+		return
+	}
+	if mention.AuthorURL == "" {
+		mention.Thumbnail = m.thumbnail(ctx, u2r, photo)
+		return
+	}
+	if profile := m.authorProfile(ctx, mention.AuthorURL); profile != nil && profile.Photo == photo {
+		mention.Thumbnail = profile.Thumbnail
+		return
+	}
+	mention.Thumbnail = m.thumbnail(ctx, u2r, photo)
+	m.putAuthorProfile(ctx, mention.AuthorURL, &AuthorProfile{
+		URL:       mention.AuthorURL,
+		Name:      mention.Author,
+		Photo:     photo,
+		Thumbnail: mention.Thumbnail,
+	})
+}
+
+// authorProfile returns the unexpired profile cached for the author page u,
+// or nil.
+func (m *Mentions) authorProfile(ctx context.Context, u string) *AuthorProfile {
+	profile, err := m.store.GetAuthorProfile(ctx, u)
+	if err != nil {
+		if err != ErrNotFound {
+			m.log.Warningf("Failed to read author profile %q: %s", u, err)
+		}
+		return nil
+	}
+	if !time.Now().Before(profile.Expires) {
+		return nil
+	}
+	return profile
+}
+
+// putAuthorProfile caches profile for the author page u, for
+// AuthorCacheDuration.
+func (m *Mentions) putAuthorProfile(ctx context.Context, u string, profile *AuthorProfile) {
+	profile.Expires = time.Now().Add(m.AuthorCacheDuration)
+	if err := m.store.PutAuthorProfile(ctx, u, profile); err != nil {
+		m.log.Warningf("Failed to write author profile %q: %s", u, err)
+	}
+}
+
+// thumbnail fetches the photo at u, and stores a 32px PNG thumbnail of it.
+// Returns the id of the thumbnail, or "" if it failed.
+func (m *Mentions) thumbnail(ctx context.Context, u2r UrlToImageReader, u string) string {
+	r, err := u2r(u)
+	if err != nil {
+		m.log.Infof("Failed to retrieve photo.")
+		return ""
+	}
+
+	defer m.close(r)
+	img, err := m.decodeImage(r)
+	if err != nil {
+		m.log.Infof("Rejected photo %q: %s", u, err)
+		return ""
+	}
+	rect := img.Bounds()
+	var x uint = 32
+	var y uint = 32
+	if rect.Max.X > rect.Max.Y {
+		y = 0
+	} else {
+		x = 0
+	}
+	resized := resize.Resize(x, y, img, resize.Lanczos3)
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{
+		CompressionLevel: png.BestCompression,
+	}
+	if err := encoder.Encode(&buf, resized); err != nil {
+		m.log.Errorf("Failed to encode photo.")
+		return ""
+	}
+
+	hash := fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
+	if err := m.store.PutThumbnail(ctx, hash, buf.Bytes()); err != nil {
+		m.log.Errorf("Failed to write: %s", err)
+		return ""
+	}
+	return hash
+}
+
+// isAbsoluteURL returns true if s is an http or https URL.
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
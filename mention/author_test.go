@@ -0,0 +1,133 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"willnorris.com/go/microformats"
+)
+
+// fixtures maps the author pages and photo used by the authorship fixtures
+// to their files.
+var fixtures = map[string]string{
+	"https://alice.example.com/":          "./testdata/authorship/alice.html",
+	"https://dave.example.com/":           "./testdata/authorship/dave.html",
+	"https://erin.example.com/":           "./testdata/authorship/erin.html",
+	"https://frank.example.com/":          "./testdata/authorship/frank.html",
+	"https://alice.example.com/photo.jpg": "./testdata/author_image.jpg",
+}
+
+// fixtureReader is a UrlToImageReader that serves fixtures.
+func fixtureReader(u string) (io.ReadCloser, error) {
+	filename, ok := fixtures[u]
+	if !ok {
+		return nil, fmt.Errorf("Not found: %s", u)
+	}
+	return os.Open(filename)
+}
+
+func parseFixture(t *testing.T, m *Mentions, u2r UrlToImageReader, fixture string) *Mention {
+	f, err := os.Open(filepath.Join("./testdata/authorship", fixture))
+	assert.NoError(t, err)
+	defer f.Close()
+	u, err := url.Parse("https://example.org/post")
+	assert.NoError(t, err)
+	data := microformats.Parse(f, u)
+	mention := New("https://example.org/post", "https://bitworking.org/bar")
+	m.findHEntry(context.Background(), u2r, mention, data, data.Items)
+	return mention
+}
+
+func TestFindAuthor(t *testing.T) {
+	testCases := []struct {
+		fixture   string
+		author    string
+		authorURL string
+		thumbnail bool
+	}{
+		// An h-card embedded in the entry, without a rel=author on the page.
+		{"hcard.html", "Alice", "https://alice.example.com/", false},
+		// An author URL, resolved to the h-card on the author's page.
+		{"url.html", "Alice Example", "https://alice.example.com/", true},
+		// An author name.
+		{"name.html", "Bob", "", false},
+		// The author of the h-feed the entry is in.
+		{"feed.html", "Carol", "https://carol.example.com/", false},
+		// The page's rel=author, resolved like an author URL.
+		{"rel_author.html", "Alice Example", "https://alice.example.com/", true},
+		// An author page whose representative h-card is found by rel=me.
+		{"rel_me.html", "Erin", "https://social.example/@erin", false},
+		// An author page with just the one h-card.
+		{"only_card.html", "Frank", "https://frank.example.com/", false},
+		// An author page without an h-card.
+		{"no_card.html", "", "https://dave.example.com/", false},
+		{"none.html", "", "", false},
+	}
+	for _, tc := range testCases {
+		mention := parseFixture(t, InitForTesting(t), fixtureReader, tc.fixture)
+		assert.Equal(t, tc.author, mention.Author, tc.fixture)
+		assert.Equal(t, tc.authorURL, mention.AuthorURL, tc.fixture)
+		assert.Equal(t, tc.thumbnail, mention.Thumbnail != "", tc.fixture)
+	}
+}
+
+func TestFindAuthorOfFirstEntry(t *testing.T) {
+	fetched := map[string]int{}
+	u2r := func(u string) (io.ReadCloser, error) {
+		fetched[u]++
+		return fixtureReader(u)
+	}
+	mention := parseFixture(t, InitForTesting(t), u2r, "entries.html")
+	assert.Equal(t, "Alice Example", mention.Author)
+	assert.Contains(t, mention.Excerpt, "Nice post")
+
+	// The first entry links to the target, so neither the reply's author nor
+	// the next entry's is fetched.
+	assert.Equal(t, map[string]int{
+		"https://alice.example.com/":          1,
+		"https://alice.example.com/photo.jpg": 1,
+	}, fetched)
+}
+
+func TestFindAuthorOfEntryForTarget(t *testing.T) {
+	mention := parseFixture(t, InitForTesting(t), fixtureReader, "target.html")
+	assert.Equal(t, "Alice Example", mention.Author)
+	assert.Equal(t, TYPE_REPLY, mention.Type)
+	assert.Contains(t, mention.Excerpt, "I agree")
+}
+
+func TestAuthorProfileCache(t *testing.T) {
+	fetched := map[string]int{}
+	u2r := func(u string) (io.ReadCloser, error) {
+		fetched[u]++
+		return fixtureReader(u)
+	}
+	m := InitForTesting(t)
+	for i := 0; i < 3; i++ {
+		mention := parseFixture(t, m, u2r, "url.html")
+		assert.Equal(t, "Alice Example", mention.Author)
+		assert.NotEmpty(t, mention.Thumbnail)
+	}
+	assert.Equal(t, 1, fetched["https://alice.example.com/"])
+	assert.Equal(t, 1, fetched["https://alice.example.com/photo.jpg"])
+
+	// Pages without an h-card are cached too.
+	parseFixture(t, m, u2r, "no_card.html")
+	parseFixture(t, m, u2r, "no_card.html")
+	assert.Equal(t, 1, fetched["https://dave.example.com/"])
+
+	// Once the profile expires the page is fetched again.
+	profile, err := m.store.GetAuthorProfile(context.Background(), "https://alice.example.com/")
+	assert.NoError(t, err)
+	profile.Expires = time.Now().Add(-time.Second)
+	assert.NoError(t, m.store.PutAuthorProfile(context.Background(), "https://alice.example.com/", profile))
+	parseFixture(t, m, u2r, "url.html")
+	assert.Equal(t, 2, fetched["https://alice.example.com/"])
+}
@@ -0,0 +1,247 @@
+package mention
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"willnorris.com/go/microformats"
+)
+
+const (
+	// MAX_CONTENT_BYTES is roughly the most sanitized HTML that's kept from
+	// the content of a mention. Longer content is cut short at a word
+	// boundary.
+	MAX_CONTENT_BYTES = 16 << 10
+
+	// EXCERPT_LENGTH is the most characters in Mention.Excerpt.
+	EXCERPT_LENGTH = 280
+)
+
+// allowedElements are the elements that SanitizeHTML keeps, along with the
+// attributes it keeps on each. Any other element is replaced by its children.
+var allowedElements = map[atom.Atom][]string{
+	atom.A:          {"href"},
+	atom.B:          nil,
+	atom.Blockquote: nil,
+	atom.Br:         nil,
+	atom.Cite:       nil,
+	atom.Code:       nil,
+	atom.Del:        nil,
+	atom.Em:         nil,
+	atom.I:          nil,
+	atom.Li:         nil,
+	atom.Ol:         nil,
+	atom.P:          nil,
+	atom.Pre:        nil,
+	atom.Q:          nil,
+	atom.S:          nil,
+	atom.Strong:     nil,
+	atom.Sub:        nil,
+	atom.Sup:        nil,
+	atom.Ul:         nil,
+}
+
+// droppedElements are removed along with everything in them.
+var droppedElements = map[atom.Atom]bool{
+	atom.Embed:    true,
+	atom.Form:     true,
+	atom.Iframe:   true,
+	atom.Math:     true,
+	atom.Noscript: true,
+	atom.Object:   true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Svg:      true,
+	atom.Template: true,
+	atom.Textarea: true,
+}
+
+// SanitizeHTML returns the HTML fragment s with only the elements and
+// attributes in allowedElements. Links are resolved against base, only kept
+// if they're http or https, and marked rel=nofollow ugc. The result is cut
+// short at a word boundary once it's about MAX_CONTENT_BYTES long.
+func SanitizeHTML(s, base string) string {
+	body := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(s), body)
+	if err != nil {
+		return ""
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		baseURL = &url.URL{}
+	}
+	sanitizer := &sanitizer{
+		base:   baseURL,
+		remain: MAX_CONTENT_BYTES,
+	}
+	for _, n := range nodes {
+		sanitizer.write(n)
+	}
+	return strings.TrimSpace(sanitizer.buf.String())
+}
+
+type sanitizer struct {
+	buf    bytes.Buffer
+	base   *url.URL
+	remain int
+}
+
+func (s *sanitizer) write(n *html.Node) {
+	if s.remain <= 0 {
+		return
+	}
+	switch n.Type {
+	case html.TextNode:
+		text := n.Data
+		if len(text) > s.remain {
+			text = truncateWords(text, s.remain)
+			s.remain = 0
+		} else {
+			s.remain -= len(text)
+		}
+		s.buf.WriteString(html.EscapeString(text))
+		return
+	case html.ElementNode:
+		if droppedElements[n.DataAtom] {
+			return
+		}
+		attrs, ok := allowedElements[n.DataAtom]
+		if !ok {
+			break
+		}
+		s.buf.WriteString("<" + n.Data)
+		for _, a := range n.Attr {
+			if a.Namespace != "" || !in(a.Key, attrs) {
+				continue
+			}
+			value := a.Val
+			if a.Key == "href" {
+				value = s.link(value)
+				if value == "" {
+					continue
+				}
+			}
+			s.buf.WriteString(" " + a.Key + `="` + html.EscapeString(value) + `"`)
+		}
+		if n.DataAtom == atom.A {
+			s.buf.WriteString(` rel="nofollow ugc"`)
+		}
+		s.buf.WriteString(">")
+		if n.DataAtom == atom.Br {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			s.write(c)
+		}
+		s.buf.WriteString("</" + n.Data + ">")
+		return
+	}
+	// Comments, doctypes, and elements that aren't allowed, whose children are
+	// kept.
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		s.write(c)
+	}
+}
+
+// link returns the absolute form of href, or "" if it isn't http or https.
+func (s *sanitizer) link(href string) string {
+	u, err := s.base.Parse(strings.TrimSpace(href))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+// truncateWords returns s cut to at most max bytes, at a word boundary if
+// there's one, followed by an ellipsis.
+func truncateWords(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	if i := strings.LastIndexFunc(s[:cut], unicode.IsSpace); i > 0 {
+		cut = i
+	}
+	return strings.TrimRightFunc(s[:cut], unicode.IsSpace) + "…"
+}
+
+// excerpt returns the text s with runs of whitespace collapsed, and cut short
+// at a word boundary if it's longer than EXCERPT_LENGTH characters.
+func excerpt(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= EXCERPT_LENGTH {
+		return s
+	}
+	// Find the byte offset of the character at EXCERPT_LENGTH.
+	n := 0
+	for i := range s {
+		if n == EXCERPT_LENGTH {
+			return truncateWords(s, i)
+		}
+		n++
+	}
+	return s
+}
+
+// textOf returns the text content of the HTML fragment s.
+func textOf(s string) string {
+	z := html.NewTokenizer(strings.NewReader(s))
+	var buf bytes.Buffer
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return buf.String()
+		case html.TextToken:
+			buf.Write(z.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			// Keep words in adjacent elements apart.
+			buf.WriteString(" ")
+		}
+	}
+}
+
+// findContent sets Content and Excerpt on mention from the content and
+// summary of the h-entry it.
+func findContent(mention *Mention, it *microformats.Microformat) {
+	content := ""
+	text := ""
+	for _, v := range it.Properties["content"] {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if h, ok := v["html"].(string); ok && strings.TrimSpace(h) != "" {
+				content = SanitizeHTML(h, mention.Source)
+			}
+			if value, ok := v["value"].(string); ok {
+				text = value
+			}
+		case string:
+			text = v
+		}
+		if content != "" || text != "" {
+			break
+		}
+	}
+	if content == "" && strings.TrimSpace(text) != "" {
+		content = SanitizeHTML(html.EscapeString(text), mention.Source)
+	}
+	if text == "" {
+		text = textOf(content)
+	}
+	if summary := firstPropAsString(it, "summary"); summary != "" {
+		text = summary
+	}
+	mention.Content = content
+	mention.Excerpt = excerpt(text)
+}
@@ -0,0 +1,73 @@
+package mention
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"willnorris.com/go/microformats"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected string
+		message  string
+	}{
+		{`<p>Hello <em>world</em></p>`, `<p>Hello <em>world</em></p>`, "allowed"},
+		{`<p onclick="evil()" class="x">Hi</p>`, `<p>Hi</p>`, "attributes"},
+		{`<script>alert(1)</script><p>Hi</p>`, `<p>Hi</p>`, "script"},
+		{`<style>p {}</style><iframe src="https://example.com/"></iframe>Hi`, `Hi`, "style and iframe"},
+		{`<div><span>Hi</span></div>`, `Hi`, "unwrapped"},
+		{`<a href="/foo">Foo</a>`, `<a href="https://example.org/foo" rel="nofollow ugc">Foo</a>`, "relative link"},
+		{`<a href="javascript:alert(1)">Foo</a>`, `<a rel="nofollow ugc">Foo</a>`, "javascript link"},
+		{`<img src="x" onerror="alert(1)">Hi`, `Hi`, "image"},
+		{`1 &lt; 2 <!-- comment -->`, `1 &lt; 2`, "escaped text and comment"},
+		{`Hi<br>there`, `Hi<br>there`, "br"},
+		{`<p>Unclosed <b>bold`, `<p>Unclosed <b>bold</b></p>`, "unclosed"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, SanitizeHTML(tc.value, "https://example.org/post"), tc.message)
+	}
+}
+
+func TestSanitizeHTMLTruncates(t *testing.T) {
+	long := "<p><b>" + strings.Repeat("word ", MAX_CONTENT_BYTES) + "</b></p>"
+	s := SanitizeHTML(long, "")
+	assert.True(t, len(s) < MAX_CONTENT_BYTES+100)
+	assert.True(t, strings.HasSuffix(s, "word…</b></p>"), s[len(s)-20:])
+}
+
+func TestExcerpt(t *testing.T) {
+	assert.Equal(t, "Hello world", excerpt("  Hello\n\n  world "))
+	long := strings.Repeat("ab ", EXCERPT_LENGTH)
+	e := excerpt(long)
+	assert.True(t, strings.HasSuffix(e, "ab…"), e)
+	assert.True(t, len([]rune(e)) <= EXCERPT_LENGTH+1)
+	// Characters, not bytes.
+	assert.Equal(t, strings.Repeat("é", EXCERPT_LENGTH), excerpt(strings.Repeat("é", EXCERPT_LENGTH)))
+	assert.Equal(t, strings.Repeat("é", EXCERPT_LENGTH)+"…", excerpt(strings.Repeat("é", EXCERPT_LENGTH+1)))
+}
+
+func TestFindContent(t *testing.T) {
+	testCases := []struct {
+		value   string
+		content string
+		excerpt string
+		message string
+	}{
+		{`<div class="h-entry"><div class="e-content"><p>Great <script>x</script>post!</p></div></div>`, `<p>Great post!</p>`, "Great post!", "html"},
+		{`<div class="h-entry"><p class="p-content">1 &lt; 2</p></div>`, `1 &lt; 2`, "1 < 2", "text"},
+		{`<div class="h-entry"><div class="e-content"><p>Long reply</p></div><p class="p-summary">Short</p></div>`, `<p>Long reply</p>`, "Short", "summary"},
+		{`<div class="h-entry"><p class="p-name">Just a name</p></div>`, ``, "", "none"},
+	}
+	u, _ := url.Parse("https://example.org/post")
+	for _, tc := range testCases {
+		data := microformats.Parse(strings.NewReader(tc.value), u)
+		mention := New("https://example.org/post", "https://bitworking.org/bar")
+		findContent(mention, data.Items[0])
+		assert.Equal(t, tc.content, mention.Content, tc.message)
+		assert.Equal(t, tc.excerpt, mention.Excerpt, tc.message)
+	}
+}
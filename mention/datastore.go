@@ -0,0 +1,311 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/webmention-func/ds"
+)
+
+const (
+	MENTIONS         ds.Kind = "Mentions"
+	WEB_MENTION_SENT ds.Kind = "WebMentionSent"
+	THUMBNAIL        ds.Kind = "Thumbnail"
+	AUTHOR_PROFILE   ds.Kind = "AuthorProfile"
+	MIGRATION        ds.Kind = "Migration"
+)
+
+type WebMentionSent struct {
+	TS time.Time
+}
+
+// Migration records that one of the datastoreMigrations was run.
+type Migration struct {
+	TS time.Time
+}
+
+type Thumbnail struct {
+	PNG []byte `datastore:",noindex"`
+}
+
+// DatastoreStore is a Store backed by Google Cloud Datastore.
+type DatastoreStore struct {
+	DS *ds.DS
+}
+
+// NewDatastoreStore creates a new DatastoreStore.
+//
+// project - The project name, i.e. "heroic-muse-88515".
+// ns      - The datastore namespace to store data into.
+func NewDatastoreStore(ctx context.Context, project, ns string) (*DatastoreStore, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	s := &DatastoreStore{
+		DS: d,
+	}
+	if err := s.migrate(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// datastoreMigrations update the entities written by earlier versions. Each
+// is run once per namespace, and recorded as a MIGRATION entity named after
+// it. Only ever append to this list.
+var datastoreMigrations = []struct {
+	name string
+	run  func(s *DatastoreStore, ctx context.Context) error
+}{
+	{"last_verified", (*DatastoreStore).backfillLastVerified},
+}
+
+func (s *DatastoreStore) migrate(ctx context.Context) error {
+	for _, migration := range datastoreMigrations {
+		key := s.DS.NewKey(MIGRATION)
+		key.Name = migration.name
+		var done Migration
+		if err := s.DS.Client.Get(ctx, key, &done); err == nil {
+			continue
+		} else if err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("Failed to read migration %q: %s", migration.name, err)
+		}
+		if err := migration.run(s, ctx); err != nil {
+			return fmt.Errorf("Failed to apply migration %q: %s", migration.name, err)
+		}
+		if _, err := s.DS.Client.Put(ctx, key, &Migration{TS: time.Now()}); err != nil {
+			return fmt.Errorf("Failed to record migration %q: %s", migration.name, err)
+		}
+	}
+	return nil
+}
+
+// backfillLastVerified adds LastVerified to the mentions written before it
+// existed. A query with an inequality filter never matches entities that
+// don't have the property, so ScheduleRecrawl would never find them.
+// They're given the zero time, so they are the first to be crawled again.
+func (s *DatastoreStore) backfillLastVerified(ctx context.Context) error {
+	keys, err := s.DS.Client.GetAll(ctx, s.DS.NewQuery(MENTIONS).KeysOnly(), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to list mentions: %s", err)
+	}
+	for _, key := range keys {
+		_, err := s.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			var props datastore.PropertyList
+			if err := tx.Get(key, &props); err != nil {
+				return err
+			}
+			for _, p := range props {
+				if p.Name == "LastVerified" {
+					return nil
+				}
+			}
+			props = append(props, datastore.Property{
+				Name:  "LastVerified",
+				Value: time.Time{},
+			})
+			_, err := tx.Put(key, &props)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to backfill %q: %s", key.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *DatastoreStore) mentionKey(key string) *datastore.Key {
+	ret := s.DS.NewKey(MENTIONS)
+	ret.Name = key
+	return ret
+}
+
+func (s *DatastoreStore) GetMention(ctx context.Context, key string) (*Mention, error) {
+	var mention Mention
+	if err := s.DS.Client.Get(ctx, s.mentionKey(key), &mention); err == datastore.ErrNoSuchEntity {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read mention: %s", err)
+	}
+	return &mention, nil
+}
+
+func (s *DatastoreStore) PutMention(ctx context.Context, key string, mention *Mention) error {
+	if _, err := s.DS.Client.Put(ctx, s.mentionKey(key), mention); err != nil {
+		return fmt.Errorf("Failed writing %#v: %s", *mention, err)
+	}
+	return nil
+}
+
+func (s *DatastoreStore) UpdateMention(ctx context.Context, key string, f func(*Mention) error) error {
+	tx, err := s.DS.Client.NewTransaction(ctx)
+	if err != nil {
+		return fmt.Errorf("client.NewTransaction: %v", err)
+	}
+	dsKey := s.mentionKey(key)
+	var mention Mention
+	if err := tx.Get(dsKey, &mention); err != nil {
+		tx.Rollback()
+		if err == datastore.ErrNoSuchEntity {
+			return ErrNotFound
+		}
+		return fmt.Errorf("tx.Get: %v", err)
+	}
+	if err := f(&mention); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Put(dsKey, &mention); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("tx.Put: %v", err)
+	}
+	if _, err = tx.Commit(); err != nil {
+		return fmt.Errorf("tx.Commit: %v", err)
+	}
+	return nil
+}
+
+// QueryMentions needs the composite indexes in index.yaml, which are deployed
+// by `make deploy`.
+func (s *DatastoreStore) QueryMentions(ctx context.Context, q *Query) ([]*MentionWithKey, error) {
+	ret := []*MentionWithKey{}
+	dsq := s.DS.NewQuery(MENTIONS)
+	if q.Target != "" {
+		dsq = dsq.Filter("Target =", q.Target)
+	}
+	if q.State != "" {
+		dsq = dsq.Filter("State =", q.State)
+	}
+	if q.Type != "" {
+		dsq = dsq.Filter("Type =", q.Type)
+	}
+	if !q.DueBefore.IsZero() {
+		dsq = dsq.Filter("NextAttempt >", time.Time{}).Filter("NextAttempt <=", q.DueBefore)
+	}
+	// Datastore can't compare (TS, key) pairs, so when starting after a
+	// mention we filter on TS and then skip the mentions that share its TS
+	// but don't come after it by key.
+	var after *Mention
+	if q.StartAfter != "" {
+		var err error
+		after, err = s.GetMention(ctx, q.StartAfter)
+		if err == ErrNotFound {
+			return ret, nil
+		} else if err != nil {
+			return ret, err
+		}
+		dsq = dsq.Filter("TS <=", after.TS)
+	}
+	if !q.VerifiedBefore.IsZero() {
+		dsq = dsq.Filter("LastVerified <", q.VerifiedBefore).Order("LastVerified").Order("__key__")
+	}
+	if q.NewestFirst {
+		dsq = dsq.Order("-TS").Order("-__key__")
+	}
+	if q.Limit > 0 && after == nil {
+		dsq = dsq.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		dsq = dsq.Offset(q.Offset)
+	}
+
+	it := s.DS.Client.Run(ctx, dsq)
+	for {
+		var mention Mention
+		key, err := it.Next(&mention)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return ret, fmt.Errorf("Failed while reading: %s", err)
+		}
+		if after != nil && mention.TS.Equal(after.TS) && key.Name >= q.StartAfter {
+			continue
+		}
+		if after != nil && q.Limit > 0 && len(ret) >= q.Limit {
+			break
+		}
+		ret = append(ret, &MentionWithKey{
+			Mention: mention,
+			Key:     key.Name,
+		})
+	}
+	return ret, nil
+}
+
+func (s *DatastoreStore) GetSent(ctx context.Context, source string) (time.Time, error) {
+	key := s.DS.NewKey(WEB_MENTION_SENT)
+	key.Name = source
+
+	dst := &WebMentionSent{}
+	if err := s.DS.Client.Get(ctx, key, dst); err == datastore.ErrNoSuchEntity {
+		return time.Time{}, ErrNotFound
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to read sent record: %s", err)
+	}
+	return dst.TS, nil
+}
+
+func (s *DatastoreStore) PutSent(ctx context.Context, source string, ts time.Time) error {
+	key := s.DS.NewKey(WEB_MENTION_SENT)
+	key.Name = source
+
+	src := &WebMentionSent{
+		TS: ts.UTC(),
+	}
+	_, err := s.DS.Client.Put(ctx, key, src)
+	return err
+}
+
+func (s *DatastoreStore) GetThumbnail(ctx context.Context, id string) ([]byte, error) {
+	key := s.DS.NewKey(THUMBNAIL)
+	key.Name = id
+	var t Thumbnail
+	if err := s.DS.Client.Get(ctx, key, &t); err == datastore.ErrNoSuchEntity {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to find image: %s", err)
+	}
+	return t.PNG, nil
+}
+
+func (s *DatastoreStore) PutThumbnail(ctx context.Context, id string, png []byte) error {
+	key := s.DS.NewKey(THUMBNAIL)
+	key.Name = id
+	t := &Thumbnail{
+		PNG: png,
+	}
+	if _, err := s.DS.Client.Put(ctx, key, t); err != nil {
+		return fmt.Errorf("Failed to write: %s", err)
+	}
+	return nil
+}
+
+func (s *DatastoreStore) GetAuthorProfile(ctx context.Context, u string) (*AuthorProfile, error) {
+	key := s.DS.NewKey(AUTHOR_PROFILE)
+	key.Name = u
+	var profile AuthorProfile
+	if err := s.DS.Client.Get(ctx, key, &profile); err == datastore.ErrNoSuchEntity {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read author profile: %s", err)
+	}
+	return &profile, nil
+}
+
+func (s *DatastoreStore) PutAuthorProfile(ctx context.Context, u string, profile *AuthorProfile) error {
+	key := s.DS.NewKey(AUTHOR_PROFILE)
+	key.Name = u
+	if _, err := s.DS.Client.Put(ctx, key, profile); err != nil {
+		return fmt.Errorf("Failed to write author profile: %s", err)
+	}
+	return nil
+}
+
+// Assert that DatastoreStore implements Store.
+var _ Store = (*DatastoreStore)(nil)
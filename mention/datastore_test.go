@@ -0,0 +1,43 @@
+package mention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatastoreBackfillLastVerified(t *testing.T) {
+	ctx := context.Background()
+	s := InitDatastoreForTesting(t)
+
+	// A mention written before LastVerified existed.
+	key := s.mentionKey("old")
+	_, err := s.DS.Client.Put(ctx, key, &datastore.PropertyList{
+		{Name: "Source", Value: "https://example.com/"},
+		{Name: "Target", Value: "https://bitworking.org/"},
+		{Name: "State", Value: GOOD_STATE},
+		{Name: "TS", Value: time.Now()},
+	})
+	assert.NoError(t, err)
+	query := &Query{
+		State:          GOOD_STATE,
+		VerifiedBefore: time.Now(),
+	}
+	found, err := s.QueryMentions(ctx, query)
+	assert.NoError(t, err)
+	assert.Len(t, found, 0)
+
+	// Run the migration again, as if this were an older namespace.
+	migration := s.DS.NewKey(MIGRATION)
+	migration.Name = "last_verified"
+	assert.NoError(t, s.DS.Client.Delete(ctx, migration))
+	assert.NoError(t, s.migrate(ctx))
+
+	found, err = s.QueryMentions(ctx, query)
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "old", found[0].Key)
+}
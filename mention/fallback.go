@@ -0,0 +1,319 @@
+package mention
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The extractors that metadata can come from, see Provenance.
+const (
+	EXTRACTOR_MICROFORMATS = "microformats"
+	EXTRACTOR_JSON_LD      = "json-ld"
+	EXTRACTOR_OPENGRAPH    = "opengraph"
+	EXTRACTOR_TWITTER_CARD = "twitter-card"
+	EXTRACTOR_HTML         = "html"
+)
+
+// Provenance records which extractor supplied each field of a mention's
+// metadata, i.e. EXTRACTOR_OPENGRAPH. Empty if the field wasn't found.
+type Provenance struct {
+	Title     string
+	Author    string
+	Published string
+	Excerpt   string
+}
+
+// metadata is what an extractor found in a page.
+type metadata struct {
+	title     string
+	author    string
+	authorURL string
+	published time.Time
+	excerpt   string
+}
+
+// page is the parts of an HTML page that the fallback extractors look at.
+type page struct {
+	// title is the text of the <title> element.
+	title string
+
+	// meta maps the property or name of each <meta> element, i.e. "og:title",
+	// to its content. Only the first of each is kept.
+	meta map[string]string
+
+	// jsonLD are the contents of the application/ld+json scripts.
+	jsonLD []string
+}
+
+// extractors are the fallbacks for metadata that isn't in an h-entry, in
+// priority order.
+var extractors = []struct {
+	name    string
+	extract func(p *page) metadata
+}{
+	{EXTRACTOR_JSON_LD, jsonLDMetadata},
+	{EXTRACTOR_OPENGRAPH, openGraphMetadata},
+	{EXTRACTOR_TWITTER_CARD, twitterCardMetadata},
+	{EXTRACTOR_HTML, htmlMetadata},
+}
+
+// findFallbackMetadata fills in the metadata of mention that wasn't found in
+// an h-entry from the rest of the HTML page b, using each of the extractors
+// in turn, and records where each field came from.
+//
+// The title of a mention that a SiloAdapter handled isn't filled in, since the
+// adapter drops the titles that Bridgy makes up, and the page's title would be
+// no better.
+func findFallbackMetadata(mention *Mention, b []byte) {
+	needTitle := mention.Title == "" && mention.Silo == ""
+	if mention.Title != "" {
+		mention.Provenance.Title = EXTRACTOR_MICROFORMATS
+	}
+	if mention.Author != "" || mention.AuthorURL != "" {
+		mention.Provenance.Author = EXTRACTOR_MICROFORMATS
+	}
+	if !mention.Published.IsZero() {
+		mention.Provenance.Published = EXTRACTOR_MICROFORMATS
+	}
+	if mention.Excerpt != "" {
+		mention.Provenance.Excerpt = EXTRACTOR_MICROFORMATS
+	}
+	if !needTitle && mention.Provenance.Author != "" && mention.Provenance.Published != "" && mention.Provenance.Excerpt != "" {
+		return
+	}
+
+	p := parsePage(b)
+	for _, e := range extractors {
+		found := e.extract(p)
+		if needTitle && mention.Title == "" && found.title != "" {
+			mention.Title = found.title
+			mention.Provenance.Title = e.name
+		}
+		if mention.Author == "" && mention.AuthorURL == "" && (found.author != "" || found.authorURL != "") {
+			mention.Author = found.author
+			mention.AuthorURL = found.authorURL
+			mention.Provenance.Author = e.name
+		}
+		if mention.Published.IsZero() && !found.published.IsZero() {
+			mention.Published = found.published
+			mention.Provenance.Published = e.name
+		}
+		if mention.Excerpt == "" && found.excerpt != "" {
+			mention.Excerpt = excerpt(found.excerpt)
+			mention.Provenance.Excerpt = e.name
+		}
+	}
+}
+
+// parsePage finds the title, meta elements and JSON-LD in the HTML page b.
+func parsePage(b []byte) *page {
+	p := &page{
+		meta:   map[string]string{},
+		jsonLD: []string{},
+	}
+	doc, err := html.Parse(bytes.NewReader(b))
+	if err != nil {
+		return p
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Title:
+				if p.title == "" {
+					p.title = strings.TrimSpace(textContent(n))
+				}
+			case atom.Meta:
+				key := attr(n, "property")
+				if key == "" {
+					key = attr(n, "name")
+				}
+				key = strings.ToLower(key)
+				if _, ok := p.meta[key]; key != "" && !ok {
+					p.meta[key] = strings.TrimSpace(attr(n, "content"))
+				}
+			case atom.Script:
+				if strings.EqualFold(strings.TrimSpace(attr(n, "type")), "application/ld+json") {
+					p.jsonLD = append(p.jsonLD, textContent(n))
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return p
+}
+
+// attr returns the value of the attribute key of n.
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// textContent returns all the text in n.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var buf bytes.Buffer
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		buf.WriteString(textContent(c))
+	}
+	return buf.String()
+}
+
+// parseTime parses the common forms of dates in meta elements and JSON-LD.
+func parseTime(s string) time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// authorFromValue returns the author of a meta element whose value is
+// either the author's URL or their name.
+func authorFromValue(value string) (string, string) {
+	if isAbsoluteURL(value) {
+		return "", value
+	}
+	return value, ""
+}
+
+func openGraphMetadata(p *page) metadata {
+	ret := metadata{
+		title:     p.meta["og:title"],
+		published: parseTime(p.meta["article:published_time"]),
+		excerpt:   p.meta["og:description"],
+	}
+	ret.author, ret.authorURL = authorFromValue(p.meta["article:author"])
+	return ret
+}
+
+func twitterCardMetadata(p *page) metadata {
+	return metadata{
+		title:   p.meta["twitter:title"],
+		author:  p.meta["twitter:creator"],
+		excerpt: p.meta["twitter:description"],
+	}
+}
+
+func htmlMetadata(p *page) metadata {
+	ret := metadata{
+		title:   p.title,
+		excerpt: p.meta["description"],
+	}
+	ret.author, ret.authorURL = authorFromValue(p.meta["author"])
+	return ret
+}
+
+// jsonLDPostTypes are the schema.org types of posts that jsonLDMetadata
+// looks for.
+var jsonLDPostTypes = []string{"BlogPosting", "Article", "NewsArticle", "SocialMediaPosting"}
+
+// jsonLDMetadata takes the metadata from the first schema.org BlogPosting,
+// or similar, in the page's JSON-LD, and its author, or else from the first
+// Person.
+func jsonLDMetadata(p *page) metadata {
+	ret := metadata{}
+	found := false
+	var person map[string]interface{}
+	for _, s := range p.jsonLD {
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			continue
+		}
+		for _, obj := range jsonLDObjects(v) {
+			if !found && hasJSONLDType(obj, jsonLDPostTypes) {
+				found = true
+				ret.title = jsonString(obj["headline"])
+				if ret.title == "" {
+					ret.title = jsonString(obj["name"])
+				}
+				ret.published = parseTime(jsonString(obj["datePublished"]))
+				ret.excerpt = jsonString(obj["description"])
+				ret.author, ret.authorURL = jsonLDAuthor(obj["author"])
+			}
+			if person == nil && hasJSONLDType(obj, []string{"Person"}) {
+				person = obj
+			}
+		}
+	}
+	if ret.author == "" && ret.authorURL == "" && person != nil {
+		ret.author, ret.authorURL = jsonLDAuthor(person)
+	}
+	return ret
+}
+
+// jsonLDObjects returns the objects in the JSON-LD value v, which may be a
+// single object, an array of them, or an object with a @graph of them.
+func jsonLDObjects(v interface{}) []map[string]interface{} {
+	ret := []map[string]interface{}{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		ret = append(ret, v)
+		ret = append(ret, jsonLDObjects(v["@graph"])...)
+	case []interface{}:
+		for _, item := range v {
+			ret = append(ret, jsonLDObjects(item)...)
+		}
+	}
+	return ret
+}
+
+// hasJSONLDType returns true if the @type of obj is one of types.
+func hasJSONLDType(obj map[string]interface{}, types []string) bool {
+	switch t := obj["@type"].(type) {
+	case string:
+		return in(t, types)
+	case []interface{}:
+		for _, s := range t {
+			if s, ok := s.(string); ok && in(s, types) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonLDAuthor returns the name and URL of the JSON-LD author v, which may be
+// a Person, or a list of them, or just a name or URL.
+func jsonLDAuthor(v interface{}) (string, string) {
+	switch v := v.(type) {
+	case string:
+		return authorFromValue(v)
+	case []interface{}:
+		if len(v) > 0 {
+			return jsonLDAuthor(v[0])
+		}
+	case map[string]interface{}:
+		u := jsonString(v["url"])
+		if u == "" {
+			u = jsonString(v["@id"])
+		}
+		if !isAbsoluteURL(u) {
+			u = ""
+		}
+		return jsonString(v["name"]), u
+	}
+	return "", ""
+}
+
+// jsonString returns v if it's a string, after trimming whitespace.
+func jsonString(v interface{}) string {
+	s, _ := v.(string)
+	return strings.TrimSpace(s)
+}
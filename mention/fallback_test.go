@@ -0,0 +1,102 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackMetadata(t *testing.T) {
+	testCases := []struct {
+		fixture    string
+		title      string
+		author     string
+		authorURL  string
+		published  string
+		excerpt    string
+		provenance Provenance
+	}{
+		{
+			fixture:    "jsonld.html",
+			title:      "JSON-LD headline",
+			author:     "Alice",
+			authorURL:  "https://alice.example.com/",
+			published:  "2019-03-01T10:00:00Z",
+			excerpt:    "JSON-LD description",
+			provenance: Provenance{EXTRACTOR_JSON_LD, EXTRACTOR_JSON_LD, EXTRACTOR_JSON_LD, EXTRACTOR_JSON_LD},
+		},
+		{
+			fixture:    "opengraph.html",
+			title:      "OpenGraph title",
+			authorURL:  "https://bob.example.com/",
+			published:  "2019-03-02T00:00:00Z",
+			excerpt:    "OpenGraph description",
+			provenance: Provenance{EXTRACTOR_OPENGRAPH, EXTRACTOR_OPENGRAPH, EXTRACTOR_OPENGRAPH, EXTRACTOR_OPENGRAPH},
+		},
+		{
+			fixture:    "twitter.html",
+			title:      "Twitter title",
+			author:     "@carol",
+			excerpt:    "Twitter description",
+			provenance: Provenance{EXTRACTOR_TWITTER_CARD, EXTRACTOR_TWITTER_CARD, "", EXTRACTOR_TWITTER_CARD},
+		},
+		{
+			fixture:    "title.html",
+			title:      "Just a title",
+			author:     "Dave",
+			excerpt:    "Meta description",
+			provenance: Provenance{EXTRACTOR_HTML, EXTRACTOR_HTML, "", EXTRACTOR_HTML},
+		},
+		{
+			fixture:    "mixed.html",
+			title:      "Microformats title",
+			author:     "Erin",
+			authorURL:  "https://erin.example.com/",
+			published:  "2019-03-04T08:00:00Z",
+			excerpt:    "Nice post about bar.",
+			provenance: Provenance{EXTRACTOR_MICROFORMATS, EXTRACTOR_JSON_LD, EXTRACTOR_OPENGRAPH, EXTRACTOR_MICROFORMATS},
+		},
+	}
+	noFetch := func(u string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("Not fetched in tests.")
+	}
+	m := InitForTesting(t)
+	for _, tc := range testCases {
+		f, err := os.Open(filepath.Join("./testdata/fallback", tc.fixture))
+		assert.NoError(t, err)
+		mention := New("https://example.org/post", "https://bitworking.org/bar")
+		m.ParseMicroformats(context.Background(), mention, f, noFetch)
+		f.Close()
+		assert.Equal(t, tc.title, mention.Title, tc.fixture)
+		assert.Equal(t, tc.author, mention.Author, tc.fixture)
+		assert.Equal(t, tc.authorURL, mention.AuthorURL, tc.fixture)
+		published := ""
+		if !mention.Published.IsZero() {
+			published = mention.Published.UTC().Format(time.RFC3339)
+		}
+		assert.Equal(t, tc.published, published, tc.fixture)
+		assert.Equal(t, tc.excerpt, mention.Excerpt, tc.fixture)
+		assert.Equal(t, tc.provenance, mention.Provenance, tc.fixture)
+	}
+}
+
+func TestFallbackSkipsSiloTitle(t *testing.T) {
+	f, err := os.Open("./testdata/fallback/bridgy.html")
+	assert.NoError(t, err)
+	defer f.Close()
+	mention := New("https://brid.gy/like/twitter/joe/123/456", "https://bitworking.org/bar")
+	m := InitForTesting(t)
+	m.ParseMicroformats(context.Background(), mention, f, func(u string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("Not fetched in tests.")
+	})
+	assert.Equal(t, SILO_TWITTER, mention.Silo)
+	assert.Equal(t, "", mention.Title)
+	assert.Equal(t, "", mention.Provenance.Title)
+	assert.Equal(t, "Bob", mention.Author)
+}
@@ -0,0 +1,96 @@
+package mention
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DEFAULT_LEASE_DURATION is the default for Mentions.LeaseDuration.
+const DEFAULT_LEASE_DURATION = 5 * time.Minute
+
+var (
+	// errNotClaimable is returned from claim if the mention is leased, or no
+	// longer needs verifying.
+	errNotClaimable = fmt.Errorf("Mention can't be claimed.")
+
+	// errLeaseLost is returned from release if the lease on the mention
+	// expired and was claimed by another verifier.
+	errLeaseLost = fmt.Errorf("Lease on mention was lost.")
+)
+
+// newOwner returns an id for a verifier that's unique across instances.
+func newOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%s-%d-%x", hostname, os.Getpid(), b)
+}
+
+// isQueued returns true if the mention is waiting to be verified.
+func isQueued(mention *Mention, now time.Time) bool {
+	return mention.State == UNTRIAGED_STATE || (!mention.NextAttempt.IsZero() && !mention.NextAttempt.After(now))
+}
+
+// claim takes a lease on the mention stored under key, in a transaction, so
+// that no other verifier verifies it until the lease expires. Returns the
+// mention as stored, or errNotClaimable if it's leased, or doesn't need
+// verifying any more.
+//
+// A lease is refused even if it's our own, since Owner is shared by every run
+// of VerifyQueuedMentions on the same Mentions, and runs can overlap.
+func (m *Mentions) claim(ctx context.Context, key string) (*Mention, error) {
+	var ret Mention
+	err := m.store.UpdateMention(ctx, key, func(mention *Mention) error {
+		now := time.Now()
+		if !isQueued(mention, now) {
+			return errNotClaimable
+		}
+		if mention.LeaseOwner != "" && mention.LeaseExpires.After(now) {
+			return errNotClaimable
+		}
+		mention.LeaseOwner = m.Owner
+		mention.LeaseExpires = now.Add(m.LeaseDuration)
+		ret = *mention
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// release writes the result of verifying mention and drops the lease on it,
+// in a transaction. state is the state the mention was in when it was
+// claimed. Returns errLeaseLost if the lease was taken over by another
+// verifier in the meantime, in which case nothing is written.
+//
+// If the mention was triaged while it was being verified then the triage
+// decision is kept, and only the lease is dropped. If it was sent again, and
+// so queued again by Receive, then it stays queued.
+func (m *Mentions) release(ctx context.Context, mention *Mention, state string) error {
+	claimed := mention.LeaseExpires.Add(-m.LeaseDuration)
+	return m.store.UpdateMention(ctx, mention.Key(), func(stored *Mention) error {
+		if stored.LeaseOwner != m.Owner {
+			return errLeaseLost
+		}
+		if stored.State == state {
+			nextAttempt, attempts := stored.NextAttempt, stored.Attempts
+			*stored = *mention
+			if nextAttempt.After(claimed) {
+				stored.NextAttempt = nextAttempt
+				stored.Attempts = attempts
+			}
+		}
+		stored.LeaseOwner = ""
+		stored.LeaseExpires = time.Time{}
+		return nil
+	})
+}
@@ -0,0 +1,91 @@
+package mention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaim(t *testing.T) {
+	ctx := context.Background()
+	m := InitForTesting(t)
+	other := NewMentionsWithStore(m.store, m.log)
+	mention := New("https://example.org/foo", "https://bitworking.org/bar")
+	assert.NoError(t, m.Put(ctx, mention))
+
+	claimed, err := m.claim(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, m.Owner, claimed.LeaseOwner)
+
+	// Leased by m, so other can't have it.
+	_, err = other.claim(ctx, mention.Key())
+	assert.Equal(t, errNotClaimable, err)
+
+	// Nor can another run with the same Owner.
+	_, err = m.claim(ctx, mention.Key())
+	assert.Equal(t, errNotClaimable, err)
+
+	// Until the lease expires.
+	assert.NoError(t, m.store.UpdateMention(ctx, mention.Key(), func(stored *Mention) error {
+		stored.LeaseExpires = time.Now().Add(-time.Second)
+		return nil
+	}))
+	_, err = other.claim(ctx, mention.Key())
+	assert.NoError(t, err)
+
+	// m lost the lease, so its result is dropped.
+	claimed.State = GOOD_STATE
+	assert.Equal(t, errLeaseLost, m.release(ctx, claimed, UNTRIAGED_STATE))
+	stored, err := m.Get(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, UNTRIAGED_STATE, stored.State)
+	assert.Equal(t, other.Owner, stored.LeaseOwner)
+}
+
+func TestReleaseKeepsTriageDecision(t *testing.T) {
+	ctx := context.Background()
+	m := InitForTesting(t)
+	mention := New("https://example.org/foo", "https://bitworking.org/bar")
+	assert.NoError(t, m.Put(ctx, mention))
+
+	claimed, err := m.claim(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.NoError(t, m.UpdateState(ctx, mention.Key(), SPAM_STATE))
+
+	claimed.State = GOOD_STATE
+	assert.NoError(t, m.release(ctx, claimed, UNTRIAGED_STATE))
+	stored, err := m.Get(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, SPAM_STATE, stored.State)
+	assert.Equal(t, "", stored.LeaseOwner)
+
+	// A mention that's been triaged can't be claimed.
+	_, err = m.claim(ctx, mention.Key())
+	assert.Equal(t, errNotClaimable, err)
+}
+
+func TestReleaseKeepsReceive(t *testing.T) {
+	ctx := context.Background()
+	m := InitForTesting(t)
+	mention := New("https://example.org/foo", "https://bitworking.org/bar")
+	mention.State = GOOD_STATE
+	mention.NextAttempt = time.Now().Add(-time.Hour)
+	assert.NoError(t, m.Put(ctx, mention))
+
+	claimed, err := m.claim(ctx, mention.Key())
+	assert.NoError(t, err)
+	// Sent again while it's being verified.
+	assert.NoError(t, m.Receive(ctx, New(mention.Source, mention.Target), mention.Target))
+
+	claimed.NextAttempt = time.Time{}
+	claimed.Title = "Foo"
+	assert.NoError(t, m.release(ctx, claimed, GOOD_STATE))
+	stored, err := m.Get(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, "Foo", stored.Title)
+	assert.Equal(t, 0, stored.Attempts)
+	assert.False(t, stored.NextAttempt.IsZero())
+	assert.Len(t, m.GetQueued(ctx), 1)
+}
@@ -0,0 +1,128 @@
+package mention
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DEFAULT_MAX_SOURCE_BYTES is the default for Mentions.MaxSourceBytes.
+	DEFAULT_MAX_SOURCE_BYTES = 2 << 20
+
+	// DEFAULT_MAX_IMAGE_BYTES is the default for Mentions.MaxImageBytes.
+	DEFAULT_MAX_IMAGE_BYTES = 2 << 20
+
+	// DEFAULT_MAX_IMAGE_DIMENSION is the default for Mentions.MaxImageWidth
+	// and Mentions.MaxImageHeight.
+	DEFAULT_MAX_IMAGE_DIMENSION = 4096
+
+	// DEFAULT_MAX_IMAGE_PIXELS is the default for Mentions.MaxImagePixels.
+	DEFAULT_MAX_IMAGE_PIXELS = 10000000
+)
+
+// DEFAULT_SOURCE_CONTENT_TYPES is the default for
+// Mentions.SourceContentTypes.
+var DEFAULT_SOURCE_CONTENT_TYPES = []string{
+	"text/html",
+	"application/xhtml+xml",
+	"text/plain",
+	"application/json",
+	"application/jf2+json",
+	"application/activity+json",
+	"application/ld+json",
+	"application/atom+xml",
+	"application/rss+xml",
+	"application/xml",
+	"text/xml",
+}
+
+// readLimited reads all of r, but no more than max bytes. A body that's any
+// longer is rejected with REASON_TOO_LARGE.
+func readLimited(r io.Reader, max int64, what string) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		verr := rejectf(REASON_BAD_BODY, "Failed to read %s: %s", what, err)
+		verr.Transient = true
+		return nil, verr
+	}
+	if int64(len(b)) > max {
+		return nil, rejectf(REASON_TOO_LARGE, "The %s is larger than %d bytes.", what, max)
+	}
+	return b, nil
+}
+
+// checkSourceResponse rejects a response for the source before its body is
+// read, if its Content-Length or Content-Type shows that it's too large or
+// not a document that can be verified.
+func (m *Mentions) checkSourceResponse(resp *http.Response) error {
+	if resp.ContentLength > m.MaxSourceBytes {
+		return rejectf(REASON_TOO_LARGE, "The source is larger than %d bytes.", m.MaxSourceBytes)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		// Decided by sniffing the body once it's read, see sourceMediaType.
+		return nil
+	}
+	_, err := m.checkContentType(contentType)
+	return err
+}
+
+// sourceMediaType returns the media type of the source, i.e. "text/html",
+// which is sniffed from the body b if the source was served without a
+// Content-Type.
+func (m *Mentions) sourceMediaType(resp *http.Response, b []byte) (string, error) {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(b)
+	}
+	return m.checkContentType(contentType)
+}
+
+// checkContentType returns the media type of contentType, or an error if it
+// isn't one of SourceContentTypes.
+func (m *Mentions) checkContentType(contentType string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", rejectf(REASON_BAD_CONTENT_TYPE, "Invalid content type %q: %s", contentType, err)
+	}
+	for _, allowed := range m.SourceContentTypes {
+		if strings.EqualFold(mediaType, allowed) {
+			return mediaType, nil
+		}
+	}
+	return "", rejectf(REASON_BAD_CONTENT_TYPE, "Content type %q isn't supported.", mediaType)
+}
+
+// decodeImage decodes an image read from r, within the Mentions image limits.
+// The dimensions are checked with image.DecodeConfig before the image is
+// decoded, so a small file that decodes to a huge image is never allocated.
+// Any error returned is a *ValidationError.
+func (m *Mentions) decodeImage(r io.Reader) (image.Image, error) {
+	b, err := readLimited(r, m.MaxImageBytes, "image")
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, rejectf(REASON_BAD_IMAGE, "Failed to decode image: %s", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, rejectf(REASON_BAD_IMAGE, "Image has no pixels.")
+	}
+	if config.Width > m.MaxImageWidth || config.Height > m.MaxImageHeight {
+		return nil, rejectf(REASON_IMAGE_TOO_LARGE, "Image is %dx%d, larger than %dx%d.", config.Width, config.Height, m.MaxImageWidth, m.MaxImageHeight)
+	}
+	if int64(config.Width)*int64(config.Height) > int64(m.MaxImagePixels) {
+		return nil, rejectf(REASON_IMAGE_TOO_LARGE, "Image has %d pixels, more than %d.", config.Width*config.Height, m.MaxImagePixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, rejectf(REASON_BAD_IMAGE, "Failed to decode image: %s", err)
+	}
+	return img, nil
+}
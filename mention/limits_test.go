@@ -0,0 +1,110 @@
+package mention
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceLimits(t *testing.T) {
+	link := `<html><body><a href="https://bitworking.org/bar">Bar</a></body></html>`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, link+strings.Repeat(" ", 1000))
+	})
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		for i := 0; i < 10; i++ {
+			fmt.Fprint(w, strings.Repeat(" ", 100))
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, link)
+	})
+	mux.HandleFunc("/pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		fmt.Fprint(w, link)
+	})
+	mux.HandleFunc("/charset", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, link)
+	})
+	mux.HandleFunc("/sniffed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		fmt.Fprint(w, link)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	testCases := []struct {
+		path   string
+		reason Reason
+	}{
+		{"/big", REASON_TOO_LARGE},
+		{"/chunked", REASON_TOO_LARGE},
+		{"/pdf", REASON_BAD_CONTENT_TYPE},
+		{"/charset", ""},
+		{"/sniffed", ""},
+	}
+	m := InitForTesting(t)
+	m.MaxSourceBytes = 500
+	for _, tc := range testCases {
+		mention := New(ts.URL+tc.path, "https://bitworking.org/bar")
+		err := m.SlowValidate(context.Background(), mention, ts.Client())
+		if tc.reason == "" {
+			assert.NoError(t, err, tc.path)
+			continue
+		}
+		verr, ok := err.(*ValidationError)
+		if assert.True(t, ok, tc.path) {
+			assert.Equal(t, tc.reason, verr.Reason, tc.path)
+			assert.False(t, verr.Transient, tc.path)
+		}
+	}
+}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func TestDecodeImageLimits(t *testing.T) {
+	m := InitForTesting(t)
+	m.MaxImageWidth = 100
+	m.MaxImageHeight = 50
+	m.MaxImagePixels = 2000
+	m.MaxImageBytes = 10000
+
+	testCases := []struct {
+		name   string
+		b      []byte
+		reason Reason
+	}{
+		{"ok", encodePNG(t, 40, 40), ""},
+		{"too wide", encodePNG(t, 101, 10), REASON_IMAGE_TOO_LARGE},
+		{"too tall", encodePNG(t, 10, 51), REASON_IMAGE_TOO_LARGE},
+		{"too many pixels", encodePNG(t, 50, 50), REASON_IMAGE_TOO_LARGE},
+		{"too many bytes", append(encodePNG(t, 40, 40), make([]byte, 10000)...), REASON_TOO_LARGE},
+		{"not an image", []byte("<html></html>"), REASON_BAD_IMAGE},
+	}
+	for _, tc := range testCases {
+		img, err := m.decodeImage(bytes.NewReader(tc.b))
+		if tc.reason == "" {
+			assert.NoError(t, err, tc.name)
+			assert.Equal(t, 40, img.Bounds().Dx(), tc.name)
+			continue
+		}
+		verr, ok := err.(*ValidationError)
+		if assert.True(t, ok, tc.name) {
+			assert.Equal(t, tc.reason, verr.Reason, tc.name)
+		}
+	}
+}
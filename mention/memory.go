@@ -0,0 +1,174 @@
+package mention
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps everything in memory.
+//
+// Useful for tests and local development.
+type MemoryStore struct {
+	mutex      sync.Mutex
+	mentions   map[string]Mention
+	sent       map[string]time.Time
+	thumbnails map[string][]byte
+	authors    map[string]AuthorProfile
+}
+
+// NewMemoryStore creates a new empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mentions:   map[string]Mention{},
+		sent:       map[string]time.Time{},
+		thumbnails: map[string][]byte{},
+		authors:    map[string]AuthorProfile{},
+	}
+}
+
+func (s *MemoryStore) GetMention(ctx context.Context, key string) (*Mention, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	mention, ok := s.mentions[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &mention, nil
+}
+
+func (s *MemoryStore) PutMention(ctx context.Context, key string, mention *Mention) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.mentions[key] = *mention
+	return nil
+}
+
+func (s *MemoryStore) UpdateMention(ctx context.Context, key string, f func(*Mention) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	mention, ok := s.mentions[key]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := f(&mention); err != nil {
+		return err
+	}
+	s.mentions[key] = mention
+	return nil
+}
+
+func (s *MemoryStore) QueryMentions(ctx context.Context, q *Query) ([]*MentionWithKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := []*MentionWithKey{}
+	var after Mention
+	if q.StartAfter != "" {
+		var ok bool
+		after, ok = s.mentions[q.StartAfter]
+		if !ok {
+			return ret, nil
+		}
+	}
+	for key, mention := range s.mentions {
+		if q.StartAfter != "" && !(mention.TS.Before(after.TS) || (mention.TS.Equal(after.TS) && key < q.StartAfter)) {
+			continue
+		}
+		if q.Target != "" && mention.Target != q.Target {
+			continue
+		}
+		if q.State != "" && mention.State != q.State {
+			continue
+		}
+		if q.Type != "" && mention.Type != q.Type {
+			continue
+		}
+		if !q.DueBefore.IsZero() && (mention.NextAttempt.IsZero() || mention.NextAttempt.After(q.DueBefore)) {
+			continue
+		}
+		if !q.VerifiedBefore.IsZero() && !mention.LastVerified.Before(q.VerifiedBefore) {
+			continue
+		}
+		ret = append(ret, &MentionWithKey{
+			Mention: mention,
+			Key:     key,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if q.NewestFirst {
+			if !ret[i].TS.Equal(ret[j].TS) {
+				return ret[i].TS.After(ret[j].TS)
+			}
+			return ret[i].Key > ret[j].Key
+		}
+		if !q.VerifiedBefore.IsZero() && !ret[i].LastVerified.Equal(ret[j].LastVerified) {
+			return ret[i].LastVerified.Before(ret[j].LastVerified)
+		}
+		return ret[i].Key < ret[j].Key
+	})
+	if q.Offset > 0 {
+		if q.Offset >= len(ret) {
+			return []*MentionWithKey{}, nil
+		}
+		ret = ret[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(ret) {
+		ret = ret[:q.Limit]
+	}
+	return ret, nil
+}
+
+func (s *MemoryStore) GetSent(ctx context.Context, source string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ts, ok := s.sent[source]
+	if !ok {
+		return time.Time{}, ErrNotFound
+	}
+	return ts, nil
+}
+
+func (s *MemoryStore) PutSent(ctx context.Context, source string, ts time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sent[source] = ts.UTC()
+	return nil
+}
+
+func (s *MemoryStore) GetThumbnail(ctx context.Context, id string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.thumbnails[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
+
+func (s *MemoryStore) PutThumbnail(ctx context.Context, id string, png []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.thumbnails[id] = png
+	return nil
+}
+
+func (s *MemoryStore) GetAuthorProfile(ctx context.Context, u string) (*AuthorProfile, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	profile, ok := s.authors[u]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &profile, nil
+}
+
+func (s *MemoryStore) PutAuthorProfile(ctx context.Context, u string, profile *AuthorProfile) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.authors[u] = *profile
+	return nil
+}
+
+// Assert that MemoryStore implements Store.
+var _ Store = (*MemoryStore)(nil)
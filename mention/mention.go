@@ -5,32 +5,18 @@ import (
 	"context"
 	"crypto/md5"
 	"fmt"
-	"image"
 	_ "image/gif"
 	_ "image/jpeg"
-	"image/png"
 	_ "image/png"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"strings"
 	"time"
 
-	"cloud.google.com/go/datastore"
-	"google.golang.org/api/iterator"
 	"willnorris.com/go/microformats"
-	"willnorris.com/go/webmention"
 
 	"github.com/jcgregorio/slog"
-	"github.com/jcgregorio/webmention-func/ds"
-	"github.com/nfnt/resize"
-)
-
-const (
-	MENTIONS         ds.Kind = "Mentions"
-	WEB_MENTION_SENT ds.Kind = "WebMentionSent"
-	THUMBNAIL        ds.Kind = "Thumbnail"
 )
 
 func (m *Mentions) close(c io.Closer) {
@@ -39,57 +25,170 @@ func (m *Mentions) close(c io.Closer) {
 	}
 }
 
+const (
+	// DEFAULT_MAX_ATTEMPTS is the default for Mentions.MaxAttempts.
+	DEFAULT_MAX_ATTEMPTS = 8
+
+	// DEFAULT_RETRY_BACKOFF is the default for Mentions.RetryBackoff.
+	DEFAULT_RETRY_BACKOFF = time.Minute
+
+	// MAX_RETRY_BACKOFF caps the delay between verification attempts.
+	MAX_RETRY_BACKOFF = 24 * time.Hour
+)
+
 type Mentions struct {
-	DS  *ds.DS
-	log slog.Logger
+	// Workers is the number of mentions that VerifyQueuedMentions verifies
+	// at the same time.
+	Workers int
+
+	// PerHostLimit is the number of mentions from the same source host that
+	// VerifyQueuedMentions verifies at the same time.
+	PerHostLimit int
+
+	// MaxAttempts is the number of times verification of a mention is
+	// attempted before it's moved to FAILED_STATE.
+	MaxAttempts int
+
+	// RetryBackoff is the delay before the first retry of a failed
+	// verification. The delay doubles with each attempt after that.
+	RetryBackoff time.Duration
+
+	// Owner identifies this instance when it claims mentions to verify, so
+	// that concurrent verifiers don't verify the same mention.
+	Owner string
+
+	// LeaseDuration is how long a claim on a mention lasts. If the verifier
+	// that claimed a mention crashes then the mention can be claimed again
+	// once the lease has expired.
+	LeaseDuration time.Duration
+
+	// MaxSourceBytes is the largest source document that will be verified.
+	MaxSourceBytes int64
+
+	// SourceContentTypes are the media types, i.e. "text/html", that a
+	// source may be served as.
+	SourceContentTypes []string
+
+	// MaxImageBytes is the largest author photo that will be read.
+	MaxImageBytes int64
+
+	// MaxImageWidth and MaxImageHeight are the largest dimensions of an author
+	// photo that will be decoded.
+	MaxImageWidth  int
+	MaxImageHeight int
+
+	// MaxImagePixels is the most pixels an author photo that will be decoded
+	// can have.
+	MaxImagePixels int
+
+	// URLPolicy is how URLs are normalized before they're compared, both when
+	// matching the links in a source to its target, and when looking up the
+	// mentions of a target.
+	URLPolicy URLPolicy
+
+	// TargetChecker, if not nil, is used to reject mentions of pages that
+	// don't exist on the site.
+	TargetChecker *TargetChecker
+
+	// RecrawlBudget is the most good mentions that ScheduleRecrawl schedules
+	// to be verified again in one run.
+	RecrawlBudget int
+
+	// RecrawlAfter is how long after a good mention was last verified that
+	// ScheduleRecrawl may schedule it to be verified again.
+	RecrawlAfter time.Duration
+
+	// AuthorCacheDuration is how long the profile of an author, found when
+	// verifying one of their mentions, is used for their other mentions
+	// before their page and photo are fetched again.
+	AuthorCacheDuration time.Duration
+
+	// SiloAdapters tidy up the metadata of mentions that Bridgy copied from
+	// silos. The first one that recognizes a mention's source is used.
+	SiloAdapters []SiloAdapter
+
+	store Store
+	log   slog.Logger
 }
 
+// NewMentions creates a new Mentions backed by Cloud Datastore.
 func NewMentions(ctx context.Context, project, ns string, log slog.Logger) (*Mentions, error) {
-	d, err := ds.New(ctx, project, ns)
+	store, err := NewDatastoreStore(ctx, project, ns)
 	if err != nil {
 		return nil, err
 	}
-	return &Mentions{
-		DS:  d,
-		log: log,
-	}, nil
+	return NewMentionsWithStore(store, log), nil
 }
 
-type WebMentionSent struct {
-	TS time.Time
+// NewMentionsWithStore creates a new Mentions backed by the given Store.
+func NewMentionsWithStore(store Store, log slog.Logger) *Mentions {
+	return &Mentions{
+		Workers:       DEFAULT_WORKERS,
+		PerHostLimit:  DEFAULT_PER_HOST_LIMIT,
+		MaxAttempts:   DEFAULT_MAX_ATTEMPTS,
+		RetryBackoff:  DEFAULT_RETRY_BACKOFF,
+		Owner:         newOwner(),
+		LeaseDuration: DEFAULT_LEASE_DURATION,
+
+		MaxSourceBytes:     DEFAULT_MAX_SOURCE_BYTES,
+		SourceContentTypes: DEFAULT_SOURCE_CONTENT_TYPES,
+		MaxImageBytes:      DEFAULT_MAX_IMAGE_BYTES,
+		MaxImageWidth:      DEFAULT_MAX_IMAGE_DIMENSION,
+		MaxImageHeight:     DEFAULT_MAX_IMAGE_DIMENSION,
+		MaxImagePixels:     DEFAULT_MAX_IMAGE_PIXELS,
+
+		RecrawlBudget: DEFAULT_RECRAWL_BUDGET,
+		RecrawlAfter:  DEFAULT_RECRAWL_AFTER,
+
+		AuthorCacheDuration: DEFAULT_AUTHOR_CACHE_DURATION,
+		SiloAdapters:        DEFAULT_SILO_ADAPTERS,
+
+		store: store,
+		log:   log,
+	}
 }
 
 func (m *Mentions) sent(source string) (time.Time, bool) {
-	key := m.DS.NewKey(WEB_MENTION_SENT)
-	key.Name = source
-
-	dst := &WebMentionSent{}
-	if err := m.DS.Client.Get(context.Background(), key, dst); err != nil {
+	ts, err := m.store.GetSent(context.Background(), source)
+	if err != nil {
 		m.log.Warningf("Failed to find source: %q", source)
 		return time.Time{}, false
 	} else {
 		m.log.Infof("Found source: %q", source)
-		return dst.TS, true
+		return ts, true
 	}
 }
 
 func (m *Mentions) recordSent(source string, updated time.Time) error {
-	key := m.DS.NewKey(WEB_MENTION_SENT)
-	key.Name = source
-
-	src := &WebMentionSent{
-		TS: updated.UTC(),
-	}
-	_, err := m.DS.Client.Put(context.Background(), key, src)
-	return err
+	return m.store.PutSent(context.Background(), source, updated)
 }
 
 const (
 	GOOD_STATE      = "good"
 	UNTRIAGED_STATE = "untriaged"
 	SPAM_STATE      = "spam"
+
+	// RETRY_STATE is for mentions whose verification failed in a way that
+	// might be temporary, i.e. a timeout. They are verified again once
+	// NextAttempt has passed.
+	RETRY_STATE = "retry"
+
+	// FAILED_STATE is for mentions that were still failing verification
+	// after Mentions.MaxAttempts attempts.
+	FAILED_STATE = "failed"
+
+	// DELETED_STATE is for mentions whose source is gone, or no longer links
+	// to the target, when it's verified again.
+	DELETED_STATE = "deleted"
 )
 
+// states are all the states a mention can be in.
+var states = []string{GOOD_STATE, UNTRIAGED_STATE, SPAM_STATE, RETRY_STATE, FAILED_STATE, DELETED_STATE}
+
+// ErrUnknownState is returned by UpdateState for a state that isn't one of
+// the *_STATE constants.
+var ErrUnknownState = fmt.Errorf("Unknown state.")
+
 type Mention struct {
 	Source string
 	Target string
@@ -102,6 +201,54 @@ type Mention struct {
 	AuthorURL string    `datastore:",noindex"`
 	Published time.Time `datastore:",noindex"`
 	Thumbnail string    `datastore:",noindex"`
+
+	// Content is the sanitized HTML content of the source, see SanitizeHTML,
+	// and Excerpt is a short plain text summary of it.
+	Content string `datastore:",noindex"`
+	Excerpt string `datastore:",noindex"`
+
+	// OriginalURL is the URL of the post the source is a copy of, i.e. the
+	// post on Mastodon that a bridged mention came from, and Syndication are
+	// the URLs of the source's own copies on other sites. They're used to find
+	// duplicates of the same post.
+	OriginalURL string   `datastore:",noindex"`
+	Syndication []string `datastore:",noindex"`
+
+	// Silo is the silo, i.e. SILO_MASTODON, that Bridgy copied the mention
+	// from, see SiloAdapter. Empty for mentions that didn't come from Bridgy.
+	Silo string `datastore:",noindex"`
+
+	// Provenance records which extractor each part of the metadata came
+	// from, i.e. the h-entry, or OpenGraph tags if there wasn't one.
+	Provenance Provenance `datastore:",noindex"`
+
+	// Type is how the source refers to the target, i.e. TYPE_REPLY, or
+	// TYPE_MENTION if it just links to it. Empty for mentions that haven't
+	// been verified yet.
+	Type string
+
+	// The results of the last verification attempt.
+	LastVerified  time.Time
+	RejectReason  Reason `datastore:",noindex"`
+	RejectMessage string `datastore:",noindex"`
+
+	// Attempts is the number of verification attempts that have failed in a
+	// row, and NextAttempt is when the mention is next due to be verified,
+	// i.e. a mention in RETRY_STATE, or a triaged mention that was sent
+	// again. Zero if it isn't due.
+	Attempts    int `datastore:",noindex"`
+	NextAttempt time.Time
+
+	// AutoDeleted is true if the mention was moved to DELETED_STATE when it
+	// was verified again, because its source was gone or no longer linked to
+	// the target, rather than by an admin. Only those mentions come back if
+	// their source verifies again.
+	AutoDeleted bool `datastore:",noindex"`
+
+	// LeaseOwner is the Mentions.Owner of the verifier that has claimed the
+	// mention, until LeaseExpires.
+	LeaseOwner   string    `datastore:",noindex"`
+	LeaseExpires time.Time `datastore:",noindex"`
 }
 
 func New(source, target string) *Mention {
@@ -113,143 +260,289 @@ func New(source, target string) *Mention {
 	}
 }
 
-func (m *Mention) key() string {
+// Key returns the key the mention is stored under, which is derived from its
+// source and target.
+func (m *Mention) Key() string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(m.Source+m.Target)))
 }
 
-func (m *Mention) FastValidate() error {
+// FastValidate does the checks on a mention that don't require fetching the
+// source. The target must be an https URL on one of hosts, where both are
+// compared as normalized by policy.
+//
+// The Target of the mention is replaced with its normalized form, so that
+// all the mentions of a page are stored under the same Target.
+func (m *Mention) FastValidate(hosts []string, policy URLPolicy) error {
 	if m.Source == "" {
 		return fmt.Errorf("Source is empty.")
 	}
 	if m.Target == "" {
 		return fmt.Errorf("Target is empty.")
 	}
-	if m.Target == m.Source {
+	normalized, err := policy.Normalize(m.Target)
+	if err != nil {
+		return fmt.Errorf("Target is not a valid URL: %s", err)
+	}
+	if policy.Equal(m.Source, normalized) {
 		return fmt.Errorf("Source and Target must be different.")
 	}
-	target, err := url.Parse(m.Target)
+	target, err := url.Parse(normalized)
 	if err != nil {
 		return fmt.Errorf("Target is not a valid URL: %s", err)
 	}
-	if target.Hostname() != "bitworking.org" {
+	found := false
+	for _, host := range hosts {
+		if target.Hostname() == policy.NormalizeHost(host) {
+			found = true
+			break
+		}
+	}
+	if !found {
 		return fmt.Errorf("Wrong target domain.")
 	}
 	if target.Scheme != "https" {
 		return fmt.Errorf("Wrong scheme for target.")
 	}
+	m.Target = normalized
 	return nil
 }
 
-func (m *Mentions) SlowValidate(mention *Mention, c *http.Client) error {
+// SlowValidate fetches the source of the mention and confirms that it links
+// to the target. Any error returned is a *ValidationError.
+//
+// The source comes from an anonymous caller, so outside of tests c should be
+// a safehttp client.
+func (m *Mentions) SlowValidate(ctx context.Context, mention *Mention, c *http.Client) error {
 	m.log.Infof("SlowValidate: %q", mention.Source)
-	resp, err := c.Get(mention.Source)
+	req, err := http.NewRequest("GET", mention.Source, nil)
 	if err != nil {
-		return fmt.Errorf("Failed to retrieve source: %s", err)
+		return rejectf(REASON_FETCH_FAILED, "Invalid source: %s", err)
+	}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		verr := rejectf(REASON_FETCH_FAILED, "Failed to retrieve source: %s", err)
+		verr.Transient = true
+		return verr
 	}
 	defer m.close(resp.Body)
-	b, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusGone {
+		return rejectf(REASON_GONE, "Source is gone.")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		verr := rejectf(REASON_BAD_STATUS, "Source responded with status %d.", resp.StatusCode)
+		// Only a 4xx is a definitive answer, except for 429 Too Many Requests.
+		verr.Transient = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return verr
+	}
+	if err := m.checkSourceResponse(resp); err != nil {
+		return err
+	}
+	b, err := readLimited(resp.Body, m.MaxSourceBytes, "source")
 	if err != nil {
-		return fmt.Errorf("Failed to read content: %s", err)
+		return err
 	}
-	reader := bytes.NewReader(b)
-	links, err := webmention.DiscoverLinksFromReader(reader, mention.Source, "")
+	mediaType, err := m.sourceMediaType(resp, b)
 	if err != nil {
-		return fmt.Errorf("Failed to discover links: %s", err)
+		return err
 	}
-	for _, link := range links {
-		if link == mention.Target {
-			_, err := reader.Seek(0, io.SeekStart)
-			if err != nil {
-				return nil
-			}
-			m.ParseMicroformats(mention, reader, MakeUrlToImageReader(c))
-			return nil
+	found, err := linksTo(m.URLPolicy, mediaType, b, mention.Source, mention.Target)
+	if err != nil {
+		return rejectf(REASON_BAD_BODY, "Failed to discover links: %s", err)
+	}
+	if found {
+		// The source may have changed since it was last verified.
+		mention.clearMetadata()
+		mention.Type = TYPE_MENTION
+		if isHTML(mediaType) {
+			m.ParseMicroformats(ctx, mention, bytes.NewReader(b), MakeUrlToImageReader(ctx, c))
 		}
+		return nil
 	}
-	return fmt.Errorf("Failed to find target link in source.")
+	return rejectf(REASON_NO_LINK, "Failed to find target link in source.")
 }
 
-func (m *Mentions) ParseMicroformats(mention *Mention, r io.Reader, urlToImageReader UrlToImageReader) {
+// ParseMicroformats sets the metadata of mention from the h-entry in the HTML
+// page r, and fills in what's missing from the page's JSON-LD, OpenGraph,
+// Twitter card and other meta tags, see findFallbackMetadata. ctx is used for
+// looking up the author.
+func (m *Mentions) ParseMicroformats(ctx context.Context, mention *Mention, r io.Reader, urlToImageReader UrlToImageReader) {
 	u, err := url.Parse(mention.Source)
 	if err != nil {
 		return
 	}
-	data := microformats.Parse(r, u)
-	m.findHEntry(context.Background(), urlToImageReader, mention, data, data.Items)
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	data := microformats.Parse(bytes.NewReader(b), u)
+	m.findHEntry(ctx, urlToImageReader, mention, data, data.Items)
+	findFallbackMetadata(mention, b)
 }
 
-func (m *Mentions) VerifyQueuedMentions(c *http.Client) {
-	queued := m.GetQueued(context.Background())
-	m.log.Infof("About to slow verify %d queud mentions.", len(queued))
-	for _, mention := range queued {
-		m.log.Infof("Verifying queued webmention from %q", mention.Source)
-		if m.SlowValidate(mention, c) == nil {
+// verify runs SlowValidate on mention and records the result on it.
+//
+// Failures that might be temporary put the mention in RETRY_STATE, with
+// NextAttempt set by exponential backoff, until MaxAttempts is reached.
+//
+// A mention that's already been triaged, and is being verified again, keeps
+// its triage decision, unless its source is gone or no longer links to the
+// target, in which case it's moved to DELETED_STATE. A mention deleted that
+// way comes back once its source verifies again, but one that an admin
+// deleted stays deleted.
+func (m *Mentions) verify(ctx context.Context, mention *Mention, c *http.Client) {
+	previous := mention.State
+	triaged := previous == GOOD_STATE || previous == SPAM_STATE || previous == DELETED_STATE
+	mention.LastVerified = time.Now()
+	err := m.SlowValidate(ctx, mention, c)
+	if err == nil {
+		if previous != SPAM_STATE && (previous != DELETED_STATE || mention.AutoDeleted) {
 			mention.State = GOOD_STATE
+			mention.AutoDeleted = false
+		}
+		mention.RejectReason = ""
+		mention.RejectMessage = ""
+		mention.Attempts = 0
+		mention.NextAttempt = time.Time{}
+		return
+	}
+	mention.Attempts++
+	mention.RejectMessage = err.Error()
+	verr, ok := err.(*ValidationError)
+	if ok {
+		mention.RejectReason = verr.Reason
+	}
+	if ok && verr.Transient {
+		if mention.Attempts >= m.MaxAttempts {
+			mention.NextAttempt = time.Time{}
+			if !triaged {
+				mention.State = FAILED_STATE
+			}
+			m.log.Infof("Giving up on webmention after %d attempts: %#v", mention.Attempts, *mention)
 		} else {
-			mention.State = SPAM_STATE
-			m.log.Infof("Failed to validate webmention: %#v", *mention)
+			mention.NextAttempt = mention.LastVerified.Add(m.backoff(mention.Attempts))
+			if !triaged {
+				mention.State = RETRY_STATE
+			}
+			m.log.Infof("Will retry webmention at %s: %#v", mention.NextAttempt, *mention)
+		}
+		return
+	}
+	mention.NextAttempt = time.Time{}
+	if ok && (verr.Reason == REASON_GONE || (verr.Reason == REASON_NO_LINK && triaged)) {
+		if previous != DELETED_STATE {
+			mention.State = DELETED_STATE
+			mention.AutoDeleted = true
 		}
-		if err := m.Put(context.Background(), mention); err != nil {
-			m.log.Warningf("Failed to save validated message: %s", err)
+		m.log.Infof("Deleted webmention: %#v", *mention)
+		return
+	}
+	if triaged {
+		m.log.Infof("Failed to verify triaged webmention again: %#v", *mention)
+		return
+	}
+	mention.State = SPAM_STATE
+	m.log.Infof("Failed to validate webmention: %#v", *mention)
+}
+
+// clearMetadata clears the metadata found when the source was last verified.
+func (m *Mention) clearMetadata() {
+	m.Title = ""
+	m.Author = ""
+	m.AuthorURL = ""
+	m.Published = time.Time{}
+	m.Thumbnail = ""
+	m.Content = ""
+	m.Excerpt = ""
+	m.OriginalURL = ""
+	m.Syndication = nil
+	m.Silo = ""
+	m.Provenance = Provenance{}
+	m.Type = ""
+}
+
+// backoff returns how long to wait before the next verification attempt,
+// after the given number of failed attempts.
+func (m *Mentions) backoff(attempts int) time.Duration {
+	ret := m.RetryBackoff
+	for i := 1; i < attempts; i++ {
+		ret *= 2
+		if ret >= MAX_RETRY_BACKOFF {
+			return MAX_RETRY_BACKOFF
 		}
 	}
+	return ret
 }
 
+// get returns the mentions of target, which is normalized first since that's
+// how FastValidate stores it. Mentions stored before targets were normalized
+// are found by also looking up target as given.
 func (m *Mentions) get(ctx context.Context, target string, all bool) []*Mention {
-	ret := []*Mention{}
-	q := m.DS.NewQuery(MENTIONS).
-		Filter("Target =", target)
+	state := ""
 	if !all {
-		q = q.Filter("State =", GOOD_STATE)
+		state = GOOD_STATE
+	}
+	normalized, err := m.URLPolicy.Normalize(target)
+	if err != nil || normalized == target {
+		return m.query(ctx, &Query{
+			Target: target,
+			State:  state,
+		})
 	}
+	ret := m.query(ctx, &Query{
+		Target: normalized,
+		State:  state,
+	})
+	return append(ret, m.query(ctx, &Query{
+		Target: target,
+		State:  state,
+	})...)
+}
 
-	it := m.DS.Client.Run(ctx, q)
-	for {
-		mention := &Mention{}
-		_, err := it.Next(mention)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			m.log.Infof("Failed while reading: %s", err)
-			break
-		}
-		ret = append(ret, mention)
+// query returns the mentions that match q, logging any errors.
+func (m *Mentions) query(ctx context.Context, q *Query) []*Mention {
+	ret := []*Mention{}
+	mentions, err := m.store.QueryMentions(ctx, q)
+	if err != nil {
+		m.log.Infof("Failed while reading: %s", err)
+	}
+	for _, mention := range mentions {
+		mention := mention.Mention
+		ret = append(ret, &mention)
 	}
 	return ret
 }
 
+// Get returns the mention stored under key, or ErrNotFound.
+func (m *Mentions) Get(ctx context.Context, key string) (*Mention, error) {
+	return m.store.GetMention(ctx, key)
+}
+
 func (m *Mentions) GetAll(ctx context.Context, target string) []*Mention {
 	return m.get(ctx, target, true)
 }
 
 func (m *Mentions) GetGood(ctx context.Context, target string) []*Mention {
-	return m.get(ctx, target, false)
+	return dedupe(m.URLPolicy, m.get(ctx, target, false))
 }
 
-func (m *Mentions) UpdateState(ctx context.Context, encodedKey, state string) error {
-	tx, err := m.DS.Client.NewTransaction(ctx)
-	if err != nil {
-		return fmt.Errorf("client.NewTransaction: %v", err)
-	}
-	key, err := datastore.DecodeKey(encodedKey)
-	if err != nil {
-		return fmt.Errorf("Unable to decode key: %s", err)
-	}
-	var mention Mention
-	if err := tx.Get(key, &mention); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("tx.GetMulti: %v", err)
-	}
-	mention.State = state
-	if _, err := tx.Put(key, &mention); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("tx.Put: %v", err)
-	}
-	if _, err = tx.Commit(); err != nil {
-		return fmt.Errorf("tx.Commit: %v", err)
-	}
-	return nil
+// UpdateState changes the state of the mention stored under key.
+//
+// Moving a mention to RETRY_STATE makes it due for verification right away,
+// and moving it to any other state cancels any verification that was due.
+// Returns ErrUnknownState if state isn't one of the *_STATE constants.
+func (m *Mentions) UpdateState(ctx context.Context, key, state string) error {
+	if !in(state, states) {
+		return ErrUnknownState
+	}
+	return m.store.UpdateMention(ctx, key, func(mention *Mention) error {
+		mention.State = state
+		mention.AutoDeleted = false
+		mention.NextAttempt = time.Time{}
+		if state == RETRY_STATE {
+			mention.NextAttempt = time.Now()
+		}
+		return nil
+	})
 }
 
 type MentionWithKey struct {
@@ -257,60 +550,87 @@ type MentionWithKey struct {
 	Key string
 }
 
-func (m *Mentions) GetTriage(ctx context.Context, limit, offset int) []*MentionWithKey {
-	ret := []*MentionWithKey{}
-	q := m.DS.NewQuery(MENTIONS).Order("-TS").Limit(limit).Offset(offset)
-
-	it := m.DS.Client.Run(ctx, q)
-	for {
-		var mention Mention
-		key, err := it.Next(&mention)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			m.log.Infof("Failed while reading: %s", err)
-			break
-		}
-		ret = append(ret, &MentionWithKey{
-			Mention: mention,
-			Key:     key.Encode(),
-		})
+// GetTriage returns up to limit mentions, newest first, starting after the
+// mention with the key startAfter, or from the newest if startAfter is empty.
+func (m *Mentions) GetTriage(ctx context.Context, limit int, startAfter string) []*MentionWithKey {
+	ret, err := m.store.QueryMentions(ctx, &Query{
+		NewestFirst: true,
+		Limit:       limit,
+		StartAfter:  startAfter,
+	})
+	if err != nil {
+		m.log.Infof("Failed while reading: %s", err)
 	}
 	return ret
 }
 
+// GetQueued returns the mentions that are due for verification, i.e. the new
+// ones, and the ones whose NextAttempt has passed.
 func (m *Mentions) GetQueued(ctx context.Context) []*Mention {
-	ret := []*Mention{}
-	q := m.DS.NewQuery(MENTIONS).
-		Filter("State =", UNTRIAGED_STATE)
-
-	it := m.DS.Client.Run(ctx, q)
-	for {
-		mention := &Mention{}
-		_, err := it.Next(mention)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			m.log.Infof("Failed while reading: %s", err)
-			break
-		}
-		ret = append(ret, mention)
+	ret := m.query(ctx, &Query{
+		State: UNTRIAGED_STATE,
+	})
+	now := time.Now()
+	for _, state := range []string{RETRY_STATE, GOOD_STATE, SPAM_STATE, DELETED_STATE} {
+		ret = append(ret, m.query(ctx, &Query{
+			State:     state,
+			DueBefore: now,
+		})...)
 	}
 	return ret
 }
 
+// Put stores mention as it is, replacing any mention with the same key. Use
+// Receive for mentions that have just been sent to us.
 func (m *Mentions) Put(ctx context.Context, mention *Mention) error {
-	// TODO See if there's an existing mention already, so we don't overwrite its status?
-	key := m.DS.NewKey(MENTIONS)
-	key.Name = mention.key()
-	if _, err := m.DS.Client.Put(ctx, key, mention); err != nil {
-		return fmt.Errorf("Failed writing %#v: %s", *mention, err)
+	return m.store.PutMention(ctx, mention.Key(), mention)
+}
+
+// Receive stores a mention that has just been sent to us, so that it gets
+// verified.
+//
+// If the mention was sent before then the stored mention is updated rather
+// than replaced. A triage decision is kept and the mention is verified
+// again, which refreshes its metadata, or moves it to DELETED_STATE if the
+// source is gone. A mention that had failed verification starts over.
+//
+// target is the target as it was sent, before FastValidate normalized it.
+// Mentions stored before targets were normalized are keyed by it, so if one
+// is found there it's updated instead, and mention.Target is set back to
+// target so that mention.Key() is the key it's stored under.
+func (m *Mentions) Receive(ctx context.Context, mention *Mention, target string) error {
+	err := m.store.UpdateMention(ctx, mention.Key(), receive)
+	if err == ErrNotFound && target != mention.Target {
+		legacy := New(mention.Source, target)
+		err = m.store.UpdateMention(ctx, legacy.Key(), receive)
+		if err == nil {
+			mention.Target = target
+		}
+	}
+	if err == ErrNotFound {
+		return m.Put(ctx, mention)
+	}
+	return err
+}
+
+// receive updates a stored mention that has been sent to us again.
+func receive(stored *Mention) error {
+	switch stored.State {
+	case UNTRIAGED_STATE:
+		// Already queued.
+	case RETRY_STATE, FAILED_STATE:
+		stored.State = UNTRIAGED_STATE
+		stored.Attempts = 0
+		stored.NextAttempt = time.Time{}
+	default:
+		stored.Attempts = 0
+		stored.NextAttempt = time.Now()
 	}
 	return nil
 }
 
+// UrlToImageReader fetches the body of url. It's used for author photos, and
+// for the author pages found by findAuthor.
 type UrlToImageReader func(url string) (io.ReadCloser, error)
 
 func in(s string, arr []string) bool {
@@ -331,111 +651,98 @@ func firstPropAsString(uf *microformats.Microformat, key string) string {
 	return ""
 }
 
+// findHEntry sets the metadata of mention from the h-entry in items that
+// refers to the target, or the first h-entry if none does. The other entries,
+// i.e. the replies to the post, are ignored, so that only the author of the
+// mention is looked up.
 func (m *Mentions) findHEntry(ctx context.Context, u2r UrlToImageReader, mention *Mention, data *microformats.Data, items []*microformats.Microformat) {
+	entries := hEntries(items, nil)
+	if len(entries) == 0 {
+		return
+	}
+	it, feed := entries[0].it, entries[0].feed
+	for _, entry := range entries {
+		if m.refersTo(entry.it, mention) {
+			it, feed = entry.it, entry.feed
+			break
+		}
+	}
+	mention.Title = firstPropAsString(it, "name")
+	if t := postType(m.URLPolicy, it, mention.Target); t != TYPE_MENTION {
+		mention.Type = t
+	}
+	findContent(mention, it)
+	findSyndication(m.URLPolicy, mention, it)
+	if t, err := time.Parse(time.RFC3339, firstPropAsString(it, "published")); err == nil {
+		mention.Published = t
+	}
+	m.findAuthor(ctx, u2r, mention, data, it, feed)
+	m.adaptSilo(it, mention)
+}
+
+// hEntry is an h-entry along with the h-feed it's in, if any.
+type hEntry struct {
+	it   *microformats.Microformat
+	feed *microformats.Microformat
+}
+
+// hEntries returns the h-entries in items, nested at any depth, in the order
+// they appear. Each is paired with the closest h-feed it's in, which is feed
+// for the top level.
+func hEntries(items []*microformats.Microformat, feed *microformats.Microformat) []hEntry {
+	ret := []hEntry{}
 	for _, it := range items {
 		if in("h-entry", it.Type) {
-			mention.Title = firstPropAsString(it, "name")
-			if strings.HasPrefix(mention.Title, "tag:twitter") {
-				mention.Title = "Twitter"
-				if firstPropAsString(it, "like-of") != "" {
-					mention.Title += " Like"
-				}
-				if firstPropAsString(it, "repost-of") != "" {
-					mention.Title += " Repost"
-				}
-			}
-			if t, err := time.Parse(time.RFC3339, firstPropAsString(it, "published")); err == nil {
-				mention.Published = t
-			}
-			if authorsInt, ok := it.Properties["author"]; ok {
-				for _, authorInt := range authorsInt {
-					if author, ok := authorInt.(*microformats.Microformat); ok {
-						m.findAuthor(ctx, u2r, mention, data, author)
-					}
-				}
-			}
+			ret = append(ret, hEntry{it: it, feed: feed})
 		}
-		m.findHEntry(ctx, u2r, mention, data, it.Children)
+		childFeed := feed
+		if in("h-feed", it.Type) {
+			childFeed = it
+		}
+		ret = append(ret, hEntries(it.Children, childFeed)...)
 	}
+	return ret
 }
 
-type Thumbnail struct {
-	PNG []byte `datastore:",noindex"`
+// refersTo returns true if the h-entry it is a reply, like, repost or
+// bookmark of the target of mention, or its content links to the target.
+func (m *Mentions) refersTo(it *microformats.Microformat, mention *Mention) bool {
+	if postType(m.URLPolicy, it, mention.Target) != TYPE_MENTION {
+		return true
+	}
+	for _, v := range it.Properties["content"] {
+		if v, ok := v.(map[string]interface{}); ok {
+			if h, ok := v["html"].(string); ok {
+				if found, err := linksTo(m.URLPolicy, "text/html", []byte(h), mention.Source, mention.Target); err == nil && found {
+					return true
+				}
+			}
+		}
+	}
+	return false
 }
 
-func MakeUrlToImageReader(c *http.Client) UrlToImageReader {
+// MakeUrlToImageReader returns a UrlToImageReader that fetches images with c,
+// which, as for SlowValidate, should be a safehttp client. The requests are
+// cancelled once ctx is done.
+func MakeUrlToImageReader(ctx context.Context, c *http.Client) UrlToImageReader {
 	return func(u string) (io.ReadCloser, error) {
-		resp, err := c.Get(u)
+		req, err := http.NewRequest("GET", u, nil)
 		if err != nil {
-			return nil, fmt.Errorf("Error retrieving thumbnail: %s", err)
+			return nil, fmt.Errorf("Invalid URL %q: %s", u, err)
+		}
+		resp, err := c.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("Error retrieving %q: %s", u, err)
 		}
 		if resp.StatusCode != 200 {
+			resp.Body.Close()
 			return nil, fmt.Errorf("Not a 200 response: %d", resp.StatusCode)
 		}
 		return resp.Body, nil
 	}
 }
 
-func (m *Mentions) findAuthor(ctx context.Context, u2r UrlToImageReader, mention *Mention, data *microformats.Data, it *microformats.Microformat) {
-	mention.Author = it.Value
-	mention.AuthorURL = data.Rels["author"][0]
-	u := firstPropAsString(it, "photo")
-	if u == "" {
-		m.log.Infof("No photo URL found.")
-		return
-	}
-
-	r, err := u2r(u)
-	if err != nil {
-		m.log.Infof("Failed to retrieve photo.")
-		return
-	}
-
-	defer m.close(r)
-	img, _, err := image.Decode(r)
-	if err != nil {
-		m.log.Infof("Failed to decode photo.")
-		return
-	}
-	rect := img.Bounds()
-	var x uint = 32
-	var y uint = 32
-	if rect.Max.X > rect.Max.Y {
-		y = 0
-	} else {
-		x = 0
-	}
-	resized := resize.Resize(x, y, img, resize.Lanczos3)
-
-	var buf bytes.Buffer
-	encoder := png.Encoder{
-		CompressionLevel: png.BestCompression,
-	}
-	if err := encoder.Encode(&buf, resized); err != nil {
-		m.log.Errorf("Failed to encode photo.")
-		return
-	}
-
-	hash := fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
-	t := &Thumbnail{
-		PNG: buf.Bytes(),
-	}
-	key := m.DS.NewKey(THUMBNAIL)
-	key.Name = hash
-	if _, err := m.DS.Client.Put(ctx, key, t); err != nil {
-		m.log.Errorf("Failed to write: %s", err)
-		return
-	}
-	mention.Thumbnail = hash
-}
-
 func (m *Mentions) GetThumbnail(ctx context.Context, id string) ([]byte, error) {
-	key := m.DS.NewKey(THUMBNAIL)
-	key.Name = id
-	var t Thumbnail
-	if err := m.DS.Client.Get(ctx, key, &t); err != nil {
-		return nil, fmt.Errorf("Failed to find image: %s", err)
-	}
-	return t.PNG, nil
-
+	return m.store.GetThumbnail(ctx, id)
 }
@@ -8,8 +8,10 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,14 +23,19 @@ import (
 	"willnorris.com/go/microformats"
 )
 
-// InitDatastore is a common utility function used in tests. It sets up the
-// datastore to connect to the emulator and also clears out all instances of
-// the given 'kinds' from the datastore.
+// InitForTesting returns a Mentions backed by a MemoryStore.
 func InitForTesting(t assert.TestingT) *Mentions {
+	return NewMentionsWithStore(NewMemoryStore(), logger.New())
+}
+
+// InitDatastoreForTesting is a common utility function used in tests. It sets
+// up a DatastoreStore that connects to the emulator, using a fresh namespace.
+// The test is skipped if the emulator isn't configured.
+func InitDatastoreForTesting(t *testing.T) *DatastoreStore {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	emulatorHost := os.Getenv("DATASTORE_EMULATOR_HOST")
 	if emulatorHost == "" {
-		assert.Fail(t, `Running tests that require a running Cloud Datastore emulator.
+		t.Skip(`Skipping tests that require a running Cloud Datastore emulator.
 
 Run
 
@@ -65,9 +72,9 @@ to set the environment variables. When done running tests you can unset the env
 	_, err := httpClient.Get("http://" + emulatorHost + "/")
 	assert.NoError(t, err, fmt.Sprintf("Cloud emulator host %s appears to be down or not accessible.", emulatorHost))
 
-	m, err := NewMentions(context.Background(), "test-project", fmt.Sprintf("test-namespace-%d", r.Uint64()), logger.New())
+	s, err := NewDatastoreStore(context.Background(), "test-project", fmt.Sprintf("test-namespace-%d", r.Uint64()))
 	assert.NoError(t, err)
-	return m
+	return s
 }
 
 func TestDB(t *testing.T) {
@@ -145,7 +152,278 @@ func TestParseMicroformats(t *testing.T) {
 	}
 	m.findHEntry(context.Background(), urlToImageReader, mention, data, data.Items)
 	assert.Equal(t, "Joe Gregorio", mention.Author)
-	assert.Equal(t, "2018-01-13 00:00:00 -0500 EST", mention.Published.String())
-	assert.Equal(t, "f3f799d1a61805b5ee2ccb5cf0aebafa", mention.Thumbnail)
+	assert.Equal(t, "2018-01-13T00:00:00-05:00", mention.Published.Format(time.RFC3339))
+	assert.Equal(t, "b7c361dba517e2c9d4107c95f4f3edb7", mention.Thumbnail)
 	assert.Equal(t, "https://bitworking.org/about", mention.AuthorURL)
 }
+
+func TestVerifyRecordsReason(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/good", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><a href="https://bitworking.org/bar">Bar</a></body></html>`)
+	})
+	mux.HandleFunc("/nolink", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><a href="https://example.com/">Elsewhere</a></body></html>`)
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not found", 404)
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unavailable", 503)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	testCases := []struct {
+		source string
+		state  string
+		reason Reason
+	}{
+		{ts.URL + "/good", GOOD_STATE, ""},
+		{ts.URL + "/nolink", SPAM_STATE, REASON_NO_LINK},
+		{ts.URL + "/gone", SPAM_STATE, REASON_BAD_STATUS},
+		{ts.URL + "/down", RETRY_STATE, REASON_BAD_STATUS},
+		{"http://127.0.0.1:1/unreachable", RETRY_STATE, REASON_FETCH_FAILED},
+	}
+	m := InitForTesting(t)
+	for _, tc := range testCases {
+		mention := New(tc.source, "https://bitworking.org/bar")
+		m.verify(context.Background(), mention, ts.Client())
+		assert.Equal(t, tc.state, mention.State, tc.source)
+		assert.Equal(t, tc.reason, mention.RejectReason, tc.source)
+		assert.False(t, mention.LastVerified.IsZero())
+		if tc.reason != "" {
+			assert.NotEmpty(t, mention.RejectMessage)
+		}
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unavailable", 503)
+	}))
+	defer ts.Close()
+
+	m := InitForTesting(t)
+	m.MaxAttempts = 3
+	ctx := context.Background()
+	mention := New(ts.URL+"/down", "https://bitworking.org/bar")
+	assert.NoError(t, m.Put(ctx, mention))
+	assert.Len(t, m.GetQueued(ctx), 1)
+
+	m.verify(context.Background(), mention, ts.Client())
+	assert.Equal(t, RETRY_STATE, mention.State)
+	assert.Equal(t, 1, mention.Attempts)
+	assert.Equal(t, DEFAULT_RETRY_BACKOFF, mention.NextAttempt.Sub(mention.LastVerified))
+	assert.NoError(t, m.Put(ctx, mention))
+	// Not due yet.
+	assert.Len(t, m.GetQueued(ctx), 0)
+
+	m.verify(context.Background(), mention, ts.Client())
+	assert.Equal(t, RETRY_STATE, mention.State)
+	assert.Equal(t, 2*DEFAULT_RETRY_BACKOFF, mention.NextAttempt.Sub(mention.LastVerified))
+
+	// Due now.
+	mention.NextAttempt = time.Now().Add(-time.Second)
+	assert.NoError(t, m.Put(ctx, mention))
+	assert.Len(t, m.GetQueued(ctx), 1)
+
+	m.verify(context.Background(), mention, ts.Client())
+	assert.Equal(t, FAILED_STATE, mention.State)
+	assert.Equal(t, 3, mention.Attempts)
+	assert.True(t, mention.NextAttempt.IsZero())
+}
+
+func TestBackoff(t *testing.T) {
+	m := InitForTesting(t)
+	assert.Equal(t, time.Minute, m.backoff(1))
+	assert.Equal(t, 2*time.Minute, m.backoff(2))
+	assert.Equal(t, 8*time.Minute, m.backoff(4))
+	assert.Equal(t, MAX_RETRY_BACKOFF, m.backoff(30))
+}
+
+func TestReceiveKeepsTriageDecision(t *testing.T) {
+	title := "First"
+	status := 200
+	link := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != 200 {
+			http.Error(w, "Gone", status)
+			return
+		}
+		body := fmt.Sprintf(`<html><body><div class="h-entry"><h1 class="p-name">%s</h1>`, title)
+		if link {
+			body += `<a href="https://bitworking.org/bar">Bar</a>`
+		}
+		fmt.Fprint(w, body+`</div></body></html>`)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := InitForTesting(t)
+	source := ts.URL + "/post"
+	assert.NoError(t, m.Receive(ctx, New(source, "https://bitworking.org/bar"), "https://bitworking.org/bar"))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	key := New(source, "https://bitworking.org/bar").Key()
+	stored, err := m.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, GOOD_STATE, stored.State)
+	assert.Equal(t, "First", stored.Title)
+	assert.Len(t, m.GetQueued(ctx), 0)
+
+	// Sending it again keeps it good, and refreshes the metadata.
+	title = "Second"
+	assert.NoError(t, m.Receive(ctx, New(source, "https://bitworking.org/bar"), "https://bitworking.org/bar"))
+	stored, err = m.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, GOOD_STATE, stored.State)
+	assert.Equal(t, "First", stored.Title)
+	assert.Len(t, m.GetQueued(ctx), 1)
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	stored, err = m.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, GOOD_STATE, stored.State)
+	assert.Equal(t, "Second", stored.Title)
+	assert.Len(t, m.GetQueued(ctx), 0)
+
+	// Only the known states can be set.
+	assert.Equal(t, ErrUnknownState, m.UpdateState(ctx, key, "bogus"))
+	stored, err = m.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, GOOD_STATE, stored.State)
+
+	// A mention triaged as spam stays spam.
+	assert.NoError(t, m.UpdateState(ctx, key, SPAM_STATE))
+	assert.NoError(t, m.Receive(ctx, New(source, "https://bitworking.org/bar"), "https://bitworking.org/bar"))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	stored, err = m.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, SPAM_STATE, stored.State)
+
+	// A good mention whose source no longer links to the target is deleted.
+	assert.NoError(t, m.UpdateState(ctx, key, GOOD_STATE))
+	link = false
+	assert.NoError(t, m.Receive(ctx, New(source, "https://bitworking.org/bar"), "https://bitworking.org/bar"))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	stored, err = m.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, DELETED_STATE, stored.State)
+	assert.Equal(t, REASON_NO_LINK, stored.RejectReason)
+	assert.Len(t, m.GetGood(ctx, "https://bitworking.org/bar"), 0)
+
+	// And comes back if the link does.
+	link = true
+	assert.NoError(t, m.Receive(ctx, New(source, "https://bitworking.org/bar"), "https://bitworking.org/bar"))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	stored, err = m.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, GOOD_STATE, stored.State)
+
+	// A source that's gone deletes the mention.
+	status = http.StatusGone
+	assert.NoError(t, m.Receive(ctx, New(source, "https://bitworking.org/bar"), "https://bitworking.org/bar"))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	stored, err = m.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, DELETED_STATE, stored.State)
+	assert.Equal(t, REASON_GONE, stored.RejectReason)
+	assert.True(t, stored.AutoDeleted)
+
+	// A mention that an admin deleted stays deleted, even once its source
+	// verifies again.
+	status = http.StatusOK
+	assert.NoError(t, m.UpdateState(ctx, key, DELETED_STATE))
+	assert.NoError(t, m.Receive(ctx, New(source, "https://bitworking.org/bar"), "https://bitworking.org/bar"))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	stored, err = m.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, DELETED_STATE, stored.State)
+	assert.False(t, stored.AutoDeleted)
+	assert.Equal(t, Reason(""), stored.RejectReason)
+}
+
+func TestReceiveRestartsFailedMention(t *testing.T) {
+	ctx := context.Background()
+	m := InitForTesting(t)
+	mention := New("https://example.org/foo", "https://bitworking.org/bar")
+	mention.State = FAILED_STATE
+	mention.Attempts = DEFAULT_MAX_ATTEMPTS
+	assert.NoError(t, m.Put(ctx, mention))
+
+	assert.NoError(t, m.Receive(ctx, New("https://example.org/foo", "https://bitworking.org/bar"), "https://bitworking.org/bar"))
+	stored, err := m.Get(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, UNTRIAGED_STATE, stored.State)
+	assert.Equal(t, 0, stored.Attempts)
+}
+
+func TestReceiveLegacyKey(t *testing.T) {
+	ctx := context.Background()
+	m := InitForTesting(t)
+	// Stored before targets were normalized, so keyed by the target as sent.
+	legacy := New("https://example.org/foo", "https://www.bitworking.org/bar/")
+	legacy.State = GOOD_STATE
+	legacy.Title = "Foo"
+	assert.NoError(t, m.Put(ctx, legacy))
+
+	mention := New("https://example.org/foo", "https://www.bitworking.org/bar/")
+	assert.NoError(t, mention.FastValidate([]string{"bitworking.org"}, URLPolicy{}))
+	assert.Equal(t, "https://bitworking.org/bar", mention.Target)
+	assert.NoError(t, m.Receive(ctx, mention, "https://www.bitworking.org/bar/"))
+	assert.Equal(t, legacy.Key(), mention.Key())
+
+	// The legacy mention is updated, not duplicated.
+	stored, err := m.Get(ctx, legacy.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, GOOD_STATE, stored.State)
+	assert.Equal(t, "Foo", stored.Title)
+	assert.Len(t, m.GetQueued(ctx), 1)
+	assert.Len(t, m.GetAll(ctx, "https://www.bitworking.org/bar/"), 1)
+}
+
+func TestUrlToImageReaderCancelled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "photo")
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	u2r := MakeUrlToImageReader(ctx, ts.Client())
+	r, err := u2r(ts.URL)
+	assert.NoError(t, err)
+	r.Close()
+
+	// Fetches stop once the verification's ctx is done.
+	cancel()
+	_, err = u2r(ts.URL)
+	assert.Error(t, err)
+}
+
+// closeRecorder is a response body that records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestUrlToImageReaderClosesNon200(t *testing.T) {
+	body := &closeRecorder{Reader: strings.NewReader("Not found")}
+	c := &http.Client{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: body, Request: r}, nil
+		}),
+	}
+	_, err := MakeUrlToImageReader(context.Background(), c)("https://example.org/photo.jpg")
+	assert.Error(t, err)
+	assert.True(t, body.closed)
+}
@@ -0,0 +1,163 @@
+package mention
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URLPolicy controls how URLs are normalized before they're compared, so
+// that a source linking to http://www.example.org/foo/ is counted as linking
+// to https://example.org/foo.
+//
+// Normalizing always lowercases the scheme and host, drops a default port,
+// and makes percent-encoding consistent. The zero value also ignores the
+// differences below, each of which can be kept.
+type URLPolicy struct {
+	// KeepScheme keeps http and https URLs distinct. Otherwise http URLs are
+	// normalized to https.
+	KeepScheme bool
+
+	// KeepWWW keeps hosts with and without a "www." prefix distinct.
+	// Otherwise the prefix is dropped.
+	KeepWWW bool
+
+	// KeepTrailingSlash keeps paths with and without a trailing slash
+	// distinct. Otherwise the slash is dropped.
+	KeepTrailingSlash bool
+
+	// KeepFragment keeps URLs that differ only in their fragment distinct.
+	// Otherwise the fragment is dropped.
+	KeepFragment bool
+}
+
+// Normalize returns the normal form of the http or https URL raw.
+func (p URLPolicy) Normalize(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("Not a valid URL: %s", err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", fmt.Errorf("Not an http or https URL: %q", raw)
+	}
+	host := p.NormalizeHost(u.Hostname())
+	if host == "" {
+		return "", fmt.Errorf("URL has no host: %q", raw)
+	}
+	port := u.Port()
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+	if !p.KeepScheme {
+		scheme = "https"
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// An IPv6 literal.
+		host = "[" + host + "]"
+	}
+
+	path := normalizeEscapes(u.EscapedPath())
+	if path == "" {
+		path = "/"
+	}
+	if !p.KeepTrailingSlash && path != "/" {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+	}
+
+	ret := scheme + "://"
+	if u.User != nil {
+		ret += u.User.String() + "@"
+	}
+	ret += host + path
+	if u.RawQuery != "" {
+		ret += "?" + normalizeEscapes(u.RawQuery)
+	}
+	if p.KeepFragment && u.Fragment != "" {
+		ret += "#" + normalizeEscapes(u.EscapedFragment())
+	}
+	return ret, nil
+}
+
+// NormalizeHost returns the normal form of the hostname host.
+func (p URLPolicy) NormalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if !p.KeepWWW {
+		host = strings.TrimPrefix(host, "www.")
+	}
+	return host
+}
+
+// Equal returns true if a and b are the same URL once normalized. URLs that
+// can't be normalized are never equal.
+func (p URLPolicy) Equal(a, b string) bool {
+	na, err := p.Normalize(a)
+	if err != nil {
+		return false
+	}
+	nb, err := p.Normalize(b)
+	if err != nil {
+		return false
+	}
+	return na == nb
+}
+
+// normalizeEscapes decodes percent-encoded unreserved characters, which never
+// need encoding, and uppercases the hex digits of all the other
+// percent-encodings, as RFC 3986 recommends.
+func normalizeEscapes(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			c := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(c) {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('%')
+				b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+			}
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+func isUnreserved(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// urlsInText finds the http and https URLs in plain text.
+var urlsInText = regexp.MustCompile("(?i)https?://[^\\s\"'<>`]+")
+
+// findURLs returns the URLs in s, without any punctuation that follows them,
+// i.e. the full stop at the end of "See https://example.org/foo.".
+func findURLs(s string) []string {
+	ret := []string{}
+	for _, u := range urlsInText.FindAllString(s, -1) {
+		ret = append(ret, strings.TrimRight(u, ".,;:!?)]}"))
+	}
+	return ret
+}
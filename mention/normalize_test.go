@@ -0,0 +1,90 @@
+package mention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		raw      string
+		expected string
+	}{
+		{"https://bitworking.org/news/2018/01/foo", "https://bitworking.org/news/2018/01/foo"},
+		{"http://bitworking.org/news/foo", "https://bitworking.org/news/foo"},
+		{"HTTPS://BitWorking.ORG/news/foo", "https://bitworking.org/news/foo"},
+		{"https://www.bitworking.org/news/foo", "https://bitworking.org/news/foo"},
+		{"https://bitworking.org/news/foo/", "https://bitworking.org/news/foo"},
+		{"https://bitworking.org/news/foo#comments", "https://bitworking.org/news/foo"},
+		{"https://bitworking.org:443/news/foo", "https://bitworking.org/news/foo"},
+		{"http://bitworking.org:80/news/foo", "https://bitworking.org/news/foo"},
+		{"http://bitworking.org:8080/news/foo", "https://bitworking.org:8080/news/foo"},
+		{"https://bitworking.org./news/foo", "https://bitworking.org/news/foo"},
+		{"https://bitworking.org", "https://bitworking.org/"},
+		{"https://bitworking.org/", "https://bitworking.org/"},
+		{"https://bitworking.org/%7Ejoe/caf%c3%a9", "https://bitworking.org/~joe/caf%C3%A9"},
+		{"https://bitworking.org/a%2fb", "https://bitworking.org/a%2Fb"},
+		{"https://bitworking.org/café", "https://bitworking.org/caf%C3%A9"},
+		{"https://bitworking.org/search?q=a%2db&x=1", "https://bitworking.org/search?q=a-b&x=1"},
+		{"  https://bitworking.org/foo  ", "https://bitworking.org/foo"},
+		{"http://[::1]:80/foo", "https://[::1]/foo"},
+	}
+	for _, tc := range testCases {
+		got, err := URLPolicy{}.Normalize(tc.raw)
+		assert.NoError(t, err, tc.raw)
+		assert.Equal(t, tc.expected, got, tc.raw)
+	}
+}
+
+func TestNormalizeKeep(t *testing.T) {
+	strict := URLPolicy{
+		KeepScheme:        true,
+		KeepWWW:           true,
+		KeepTrailingSlash: true,
+		KeepFragment:      true,
+	}
+	got, err := strict.Normalize("HTTP://www.Bitworking.org:80/news/foo/#comments")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://www.bitworking.org/news/foo/#comments", got)
+
+	assert.False(t, strict.Equal("http://bitworking.org/foo", "https://bitworking.org/foo"))
+	assert.False(t, strict.Equal("https://www.bitworking.org/foo", "https://bitworking.org/foo"))
+	assert.False(t, strict.Equal("https://bitworking.org/foo/", "https://bitworking.org/foo"))
+	assert.True(t, strict.Equal("https://bitworking.org:443/%7efoo", "https://bitworking.org/~foo"))
+}
+
+func TestNormalizeErrors(t *testing.T) {
+	for _, raw := range []string{"", "mailto:joe@bitworking.org", "ftp://bitworking.org/", "https:///foo", "/foo", "https://bitworking.org/%zz\x7f:"} {
+		_, err := URLPolicy{}.Normalize(raw)
+		assert.Error(t, err, raw)
+	}
+	assert.False(t, URLPolicy{}.Equal("/foo", "/foo"))
+}
+
+func TestFastValidate(t *testing.T) {
+	hosts := []string{"bitworking.org"}
+	testCases := []struct {
+		target   string
+		policy   URLPolicy
+		expected string
+		ok       bool
+	}{
+		{"https://bitworking.org/foo", URLPolicy{}, "https://bitworking.org/foo", true},
+		{"http://www.bitworking.org/foo/", URLPolicy{}, "https://bitworking.org/foo", true},
+		{"http://bitworking.org/foo", URLPolicy{KeepScheme: true}, "", false},
+		{"https://www.bitworking.org/foo", URLPolicy{KeepWWW: true}, "", false},
+		{"https://example.org/foo", URLPolicy{}, "", false},
+		{"https://example.org/post", URLPolicy{}, "", false},
+	}
+	for _, tc := range testCases {
+		m := New("https://example.org/post", tc.target)
+		err := m.FastValidate(hosts, tc.policy)
+		if !tc.ok {
+			assert.Error(t, err, tc.target)
+			continue
+		}
+		assert.NoError(t, err, tc.target)
+		assert.Equal(t, tc.expected, m.Target)
+	}
+}
@@ -0,0 +1,71 @@
+package mention
+
+import (
+	"strings"
+
+	"willnorris.com/go/microformats"
+)
+
+// The types of mention, see Mention.Type.
+const (
+	TYPE_MENTION  = "mention"
+	TYPE_REPLY    = "reply"
+	TYPE_LIKE     = "like"
+	TYPE_REPOST   = "repost"
+	TYPE_BOOKMARK = "bookmark"
+	TYPE_RSVP     = "rsvp"
+)
+
+// rsvpValues are the values of the rsvp property that make an RSVP.
+var rsvpValues = []string{"yes", "no", "maybe", "interested"}
+
+// postType returns the type of the h-entry it as a mention of target, using
+// Post Type Discovery (https://www.w3.org/TR/post-type-discovery/), but only
+// counting the properties that point at target. Anything else is just a
+// mention.
+func postType(policy URLPolicy, it *microformats.Microformat, target string) string {
+	refersTo := func(key string) bool {
+		for _, u := range propURLs(it, key) {
+			if policy.Equal(u, target) {
+				return true
+			}
+		}
+		return false
+	}
+	if refersTo("in-reply-to") {
+		if in(strings.ToLower(strings.TrimSpace(firstPropAsString(it, "rsvp"))), rsvpValues) {
+			return TYPE_RSVP
+		}
+		return TYPE_REPLY
+	}
+	if refersTo("repost-of") {
+		return TYPE_REPOST
+	}
+	if refersTo("like-of") {
+		return TYPE_LIKE
+	}
+	if refersTo("bookmark-of") {
+		return TYPE_BOOKMARK
+	}
+	return TYPE_MENTION
+}
+
+// propURLs returns the URLs in the property key of it, which are either plain
+// values or the url of an embedded h-cite.
+func propURLs(it *microformats.Microformat, key string) []string {
+	ret := []string{}
+	for _, v := range it.Properties[key] {
+		switch v := v.(type) {
+		case string:
+			ret = append(ret, v)
+		case *microformats.Microformat:
+			if s := firstPropAsString(v, "url"); s != "" {
+				ret = append(ret, s)
+			}
+			if v.Value != "" {
+				ret = append(ret, v.Value)
+			}
+		}
+	}
+	return ret
+}
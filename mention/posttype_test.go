@@ -0,0 +1,35 @@
+package mention
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"willnorris.com/go/microformats"
+)
+
+func TestPostType(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected string
+		message  string
+	}{
+		{`<div class="h-entry"><a class="u-in-reply-to" href="https://bitworking.org/bar">Bar</a></div>`, TYPE_REPLY, "reply"},
+		{`<div class="h-entry"><a class="u-in-reply-to" href="https://bitworking.org/bar">Bar</a><data class="p-rsvp" value="yes">Going</data></div>`, TYPE_RSVP, "rsvp"},
+		{`<div class="h-entry"><a class="u-in-reply-to" href="https://bitworking.org/bar">Bar</a><data class="p-rsvp" value="whenever">?</data></div>`, TYPE_REPLY, "invalid rsvp is a reply"},
+		{`<div class="h-entry"><a class="u-like-of" href="http://www.bitworking.org/bar/">Bar</a></div>`, TYPE_LIKE, "like of a normalized URL"},
+		{`<div class="h-entry"><a class="u-repost-of" href="https://bitworking.org/bar">Bar</a></div>`, TYPE_REPOST, "repost"},
+		{`<div class="h-entry"><a class="u-bookmark-of" href="https://bitworking.org/bar">Bar</a></div>`, TYPE_BOOKMARK, "bookmark"},
+		{`<div class="h-entry"><div class="u-like-of h-cite"><a class="u-url" href="https://bitworking.org/bar">Bar</a></div></div>`, TYPE_LIKE, "embedded h-cite"},
+		{`<div class="h-entry"><a class="u-like-of" href="https://example.com/other">Other</a><a href="https://bitworking.org/bar">Bar</a></div>`, TYPE_MENTION, "like of something else"},
+		{`<div class="h-entry"><a class="u-in-reply-to" href="https://example.com/other">Other</a><a class="u-like-of" href="https://bitworking.org/bar">Bar</a></div>`, TYPE_LIKE, "reply to something else"},
+		{`<div class="h-entry"><a href="https://bitworking.org/bar">Bar</a></div>`, TYPE_MENTION, "plain link"},
+	}
+	u, _ := url.Parse("https://example.org/post")
+	for _, tc := range testCases {
+		data := microformats.Parse(strings.NewReader(tc.value), u)
+		assert.Len(t, data.Items, 1, tc.message)
+		assert.Equal(t, tc.expected, postType(URLPolicy{}, data.Items[0], "https://bitworking.org/bar"), tc.message)
+	}
+}
@@ -0,0 +1,66 @@
+package mention
+
+import "fmt"
+
+// Reason is why a mention was rejected during verification, or why content
+// fetched while verifying it, such as the author's photo, was rejected.
+type Reason string
+
+const (
+	// REASON_FETCH_FAILED means the source couldn't be retrieved at all, i.e.
+	// a DNS failure, a refused connection, or a timeout.
+	REASON_FETCH_FAILED Reason = "fetch_failed"
+
+	// REASON_GONE means the source responded with 410 Gone.
+	REASON_GONE Reason = "gone"
+
+	// REASON_BAD_STATUS means the source responded with a non-2xx status code.
+	REASON_BAD_STATUS Reason = "bad_status"
+
+	// REASON_BAD_BODY means the body of the source couldn't be read or parsed.
+	REASON_BAD_BODY Reason = "bad_body"
+
+	// REASON_NO_LINK means the source was retrieved but doesn't link to the
+	// target.
+	REASON_NO_LINK Reason = "no_link"
+
+	// REASON_TOO_LARGE means the source, or an image, is larger than
+	// Mentions allows, see Mentions.MaxSourceBytes and Mentions.MaxImageBytes.
+	REASON_TOO_LARGE Reason = "too_large"
+
+	// REASON_BAD_CONTENT_TYPE means the source isn't one of
+	// Mentions.SourceContentTypes.
+	REASON_BAD_CONTENT_TYPE Reason = "bad_content_type"
+
+	// REASON_BAD_IMAGE means an image couldn't be decoded.
+	REASON_BAD_IMAGE Reason = "bad_image"
+
+	// REASON_IMAGE_TOO_LARGE means an image has more pixels, or is wider or
+	// taller, than Mentions allows.
+	REASON_IMAGE_TOO_LARGE Reason = "image_too_large"
+)
+
+// ValidationError is the error returned when a mention fails verification.
+type ValidationError struct {
+	Reason Reason
+
+	// Message is a human readable description of the failure.
+	Message string
+
+	// Transient is true if the failure might go away if verification is tried
+	// again later, i.e. a timeout or a 5xx response.
+	Transient bool
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// rejectf returns a ValidationError for reason, with a message formatted as
+// fmt.Sprintf does.
+func rejectf(reason Reason, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		Reason:  reason,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,62 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// DEFAULT_RECRAWL_BUDGET is the default for Mentions.RecrawlBudget.
+	DEFAULT_RECRAWL_BUDGET = 20
+
+	// DEFAULT_RECRAWL_AFTER is the default for Mentions.RecrawlAfter.
+	DEFAULT_RECRAWL_AFTER = 7 * 24 * time.Hour
+)
+
+// errNotRecrawlable is returned from inside UpdateMention to leave a mention
+// that's no longer good, or is already due, as it is.
+var errNotRecrawlable = fmt.Errorf("Mention can't be recrawled.")
+
+// ScheduleRecrawl makes up to RecrawlBudget good mentions due for
+// verification, picking the ones that were verified longest ago, and skipping
+// any verified within RecrawlAfter. Mentions scheduled by an earlier call that
+// haven't been verified yet count against the budget, so the backlog of
+// recrawls never grows beyond it. Returns the number of mentions scheduled.
+//
+// VerifyQueuedMentions then verifies them again, which refreshes their
+// metadata, records any failure so that it shows up in Triage, and moves
+// them to DELETED_STATE if their source is gone or no longer links to the
+// target.
+func (m *Mentions) ScheduleRecrawl(ctx context.Context) int {
+	now := time.Now()
+	mentions, err := m.store.QueryMentions(ctx, &Query{
+		State:          GOOD_STATE,
+		VerifiedBefore: now.Add(-m.RecrawlAfter),
+		Limit:          m.RecrawlBudget,
+	})
+	if err != nil {
+		m.log.Warningf("Failed to find mentions to recrawl: %s", err)
+		return 0
+	}
+	scheduled := 0
+	for _, found := range mentions {
+		err := m.store.UpdateMention(ctx, found.Key, func(mention *Mention) error {
+			if mention.State != GOOD_STATE || !mention.NextAttempt.IsZero() {
+				return errNotRecrawlable
+			}
+			mention.NextAttempt = now
+			return nil
+		})
+		if err == errNotRecrawlable {
+			continue
+		}
+		if err != nil {
+			m.log.Warningf("Failed to schedule recrawl of %q: %s", found.Source, err)
+			continue
+		}
+		scheduled++
+	}
+	m.log.Infof("Scheduled %d mentions to be recrawled.", scheduled)
+	return scheduled
+}
@@ -0,0 +1,94 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleRecrawl(t *testing.T) {
+	ctx := context.Background()
+	m := InitForTesting(t)
+	m.RecrawlBudget = 2
+	now := time.Now()
+	put := func(source, state string, verified time.Time) *Mention {
+		mention := New(source, "https://bitworking.org/bar")
+		mention.State = state
+		mention.LastVerified = verified
+		assert.NoError(t, m.Put(ctx, mention))
+		return mention
+	}
+	oldest := put("https://example.org/oldest", GOOD_STATE, now.Add(-30*24*time.Hour))
+	older := put("https://example.org/older", GOOD_STATE, now.Add(-20*24*time.Hour))
+	old := put("https://example.org/old", GOOD_STATE, now.Add(-10*24*time.Hour))
+	recent := put("https://example.org/recent", GOOD_STATE, now.Add(-time.Hour))
+	spam := put("https://example.org/spam", SPAM_STATE, now.Add(-30*24*time.Hour))
+
+	// Only the budget, oldest first.
+	assert.Equal(t, 2, m.ScheduleRecrawl(ctx))
+	for _, tc := range []struct {
+		mention *Mention
+		due     bool
+	}{
+		{oldest, true},
+		{older, true},
+		{old, false},
+		{recent, false},
+		{spam, false},
+	} {
+		stored, err := m.Get(ctx, tc.mention.Key())
+		assert.NoError(t, err)
+		assert.Equal(t, tc.due, !stored.NextAttempt.IsZero(), tc.mention.Source)
+	}
+
+	// Mentions that are scheduled but not verified yet use up the budget.
+	assert.Equal(t, 0, m.ScheduleRecrawl(ctx))
+	assert.Len(t, m.GetQueued(ctx), 2)
+}
+
+func TestRecrawl(t *testing.T) {
+	title := "First"
+	status := 200
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != 200 {
+			http.Error(w, "Not found", status)
+			return
+		}
+		fmt.Fprintf(w, `<html><body><div class="h-entry"><h1 class="p-name">%s</h1><a href="https://bitworking.org/bar">Bar</a></div></body></html>`, title)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := InitForTesting(t)
+	m.RecrawlAfter = 0
+	mention := New(ts.URL+"/post", "https://bitworking.org/bar")
+	assert.NoError(t, m.Receive(ctx, mention, mention.Target))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	stored, err := m.Get(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, "First", stored.Title)
+
+	// A recrawl refreshes the metadata.
+	title = "Second"
+	assert.Equal(t, 1, m.ScheduleRecrawl(ctx))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	stored, err = m.Get(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, GOOD_STATE, stored.State)
+	assert.Equal(t, "Second", stored.Title)
+
+	// A source that 404s stays good, but the failure is recorded for Triage.
+	status = http.StatusNotFound
+	assert.Equal(t, 1, m.ScheduleRecrawl(ctx))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	stored, err = m.Get(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, GOOD_STATE, stored.State)
+	assert.Equal(t, REASON_BAD_STATUS, stored.RejectReason)
+	assert.True(t, stored.NextAttempt.IsZero())
+}
@@ -0,0 +1,202 @@
+package mention
+
+import (
+	"net/url"
+	"strings"
+
+	"willnorris.com/go/microformats"
+)
+
+// The silos that Bridgy sends mentions from, see Mention.Silo.
+const (
+	SILO_TWITTER  = "twitter"
+	SILO_MASTODON = "mastodon"
+	SILO_GITHUB   = "github"
+	SILO_REDDIT   = "reddit"
+	SILO_FLICKR   = "flickr"
+)
+
+// siloNames are the display names of the silos.
+var siloNames = map[string]string{
+	SILO_TWITTER:  "Twitter",
+	SILO_MASTODON: "Mastodon",
+	SILO_GITHUB:   "GitHub",
+	SILO_REDDIT:   "Reddit",
+	SILO_FLICKR:   "Flickr",
+}
+
+// BRIDGY_HOST is the host of the sources of mentions sent by Bridgy.
+const BRIDGY_HOST = "brid.gy"
+
+// bridgyTypes maps the first part of the path of a Bridgy source, i.e.
+// "/like/twitter/...", to the type of the mention.
+var bridgyTypes = map[string]string{
+	"post":    TYPE_MENTION,
+	"comment": TYPE_REPLY,
+	"like":    TYPE_LIKE,
+	"react":   TYPE_LIKE,
+	"repost":  TYPE_REPOST,
+	"rsvp":    TYPE_RSVP,
+}
+
+// SiloAdapter tidies up the metadata of a mention that Bridgy copied from a
+// silo, whose source is a page on brid.gy.
+type SiloAdapter interface {
+	// Adapt updates mention, which has been parsed from the h-entry it, and
+	// returns true, if source is a Bridgy page for the adapter's silo.
+	// Otherwise it returns false and leaves mention alone.
+	Adapt(source *url.URL, it *microformats.Microformat, mention *Mention) bool
+}
+
+// DEFAULT_SILO_ADAPTERS is the default for Mentions.SiloAdapters.
+var DEFAULT_SILO_ADAPTERS = []SiloAdapter{
+	&BridgyAdapter{
+		Silo:   SILO_TWITTER,
+		Hosts:  []string{"twitter.com", "x.com"},
+		Handle: pathHandle("@", ""),
+	},
+	&BridgyAdapter{
+		Silo:   SILO_MASTODON,
+		Handle: mastodonHandle,
+	},
+	&BridgyAdapter{
+		Silo:   SILO_GITHUB,
+		Hosts:  []string{"github.com"},
+		Handle: pathHandle("", ""),
+	},
+	&BridgyAdapter{
+		Silo:   SILO_REDDIT,
+		Hosts:  []string{"reddit.com"},
+		Handle: pathHandle("u/", "user"),
+	},
+	&BridgyAdapter{
+		Silo:   SILO_FLICKR,
+		Hosts:  []string{"flickr.com"},
+		Handle: pathHandle("", "people"),
+	},
+}
+
+// BridgyAdapter is a SiloAdapter for one of the silos that Bridgy supports.
+//
+// Bridgy sources have paths like /{type}/{silo}/{user}/{post}/{reaction},
+// and an h-entry whose url is the reaction on the silo, and whose author is
+// the h-card of the person that reacted.
+type BridgyAdapter struct {
+	// Silo is the name of the silo in the paths of Bridgy sources, and the
+	// value of Mention.Silo.
+	Silo string
+
+	// Hosts are the hosts of the silo, which OriginalURL must be on. Empty
+	// if the silo can be on any host, i.e. Mastodon.
+	Hosts []string
+
+	// Handle returns the author's handle on the silo, i.e. "@alice", from
+	// the URL of their profile, or "" if it can't. It's used as the author's
+	// name if their h-card doesn't have one.
+	Handle func(u *url.URL) string
+}
+
+func (a *BridgyAdapter) Adapt(source *url.URL, it *microformats.Microformat, mention *Mention) bool {
+	if strings.ToLower(source.Hostname()) != BRIDGY_HOST {
+		return false
+	}
+	parts := strings.Split(strings.Trim(source.Path, "/"), "/")
+	if len(parts) < 2 || parts[1] != a.Silo {
+		return false
+	}
+	mention.Silo = a.Silo
+
+	// The type found from the h-entry is more precise, i.e. a Bridgy comment
+	// might be an RSVP.
+	if mention.Type == "" || mention.Type == TYPE_MENTION {
+		if t, ok := bridgyTypes[parts[0]]; ok {
+			mention.Type = t
+		}
+	}
+
+	// The h-entry's url is the reaction on the silo, which is what the mention
+	// is a copy of, and which findSyndication doesn't recognize if it's on a
+	// host it doesn't know, i.e. a Mastodon instance.
+	mention.OriginalURL = ""
+	for _, u := range propURLs(it, "url") {
+		if isAbsoluteURL(u) && a.onSilo(u) {
+			mention.OriginalURL = u
+			break
+		}
+	}
+
+	if mention.Author == "" && mention.AuthorURL != "" && a.Handle != nil {
+		if u, err := url.Parse(mention.AuthorURL); err == nil {
+			mention.Author = a.Handle(u)
+		}
+	}
+
+	// Bridgy names likes and reposts with tag URIs, i.e.
+	// "tag:twitter.com,2013:1234_favorited_by_5678", which aren't worth
+	// showing.
+	if strings.HasPrefix(mention.Title, "tag:") {
+		mention.Title = ""
+	}
+	return true
+}
+
+// onSilo returns true if u is on one of the silo's hosts, or their
+// subdomains, or on any host other than Bridgy's if the silo has no Hosts.
+func (a *BridgyAdapter) onSilo(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if len(a.Hosts) == 0 {
+		return host != BRIDGY_HOST
+	}
+	for _, h := range a.Hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathHandle returns a Handle func that takes the handle from the profile
+// URL's path, i.e. "/alice" or "/user/alice" if dir is "user", and adds
+// prefix to it.
+func pathHandle(prefix, dir string) func(u *url.URL) string {
+	return func(u *url.URL) string {
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if dir != "" {
+			if len(parts) < 2 || parts[0] != dir {
+				return ""
+			}
+			parts = parts[1:]
+		}
+		if parts[0] == "" {
+			return ""
+		}
+		return prefix + parts[0]
+	}
+}
+
+// mastodonHandle returns the handle of a Mastodon profile URL, i.e.
+// "@alice@mastodon.social" for https://mastodon.social/@alice.
+func mastodonHandle(u *url.URL) string {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if !strings.HasPrefix(parts[0], "@") || len(parts[0]) < 2 {
+		return ""
+	}
+	return parts[0] + "@" + u.Hostname()
+}
+
+// adaptSilo runs the SiloAdapters over mention, until one of them adapts it.
+func (m *Mentions) adaptSilo(it *microformats.Microformat, mention *Mention) {
+	source, err := url.Parse(mention.Source)
+	if err != nil {
+		return
+	}
+	for _, a := range m.SiloAdapters {
+		if a.Adapt(source, it, mention) {
+			return
+		}
+	}
+}
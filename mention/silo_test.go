@@ -0,0 +1,148 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"willnorris.com/go/microformats"
+)
+
+func TestSiloAdapters(t *testing.T) {
+	testCases := []struct {
+		source      string
+		value       string
+		silo        string
+		typ         string
+		author      string
+		originalURL string
+		title       string
+	}{
+		{
+			source: "https://brid.gy/like/twitter/joe/123/456",
+			value: `<article class="h-entry">
+				<span class="p-name">tag:twitter.com,2013:123_favorited_by_456</span>
+				<span class="p-author h-card"><a class="p-name u-url" href="https://twitter.com/bob">Bob</a></span>
+				<a class="u-url" href="https://twitter.com/joe/status/123#favorited-by-456"></a>
+				<a class="u-like-of" href="https://bitworking.org/bar"></a>
+			</article>`,
+			silo:        SILO_TWITTER,
+			typ:         TYPE_LIKE,
+			author:      "Bob",
+			originalURL: "https://twitter.com/joe/status/123#favorited-by-456",
+		},
+		{
+			source: "https://brid.gy/comment/mastodon/@joe@mastodon.social/123/789",
+			value: `<article class="h-entry">
+				<a class="p-author h-card" href="https://mastodon.social/@carol"></a>
+				<a class="u-url" href="https://mastodon.social/@carol/789"></a>
+				<p class="p-name">Great post!</p>
+				<a class="u-in-reply-to" href="https://bitworking.org/bar"></a>
+			</article>`,
+			silo:        SILO_MASTODON,
+			typ:         TYPE_REPLY,
+			author:      "@carol@mastodon.social",
+			originalURL: "https://mastodon.social/@carol/789",
+			title:       "Great post!",
+		},
+		{
+			source: "https://brid.gy/react/github/joe/1/2",
+			value: `<article class="h-entry">
+				<span class="p-author h-card"><a class="p-name u-url" href="https://github.com/erin">Erin</a></span>
+				<a class="u-url" href="https://github.com/joe/repo/issues/1#reaction-2"></a>
+				<a href="https://bitworking.org/bar"></a>
+			</article>`,
+			silo:        SILO_GITHUB,
+			typ:         TYPE_LIKE,
+			author:      "Erin",
+			originalURL: "https://github.com/joe/repo/issues/1#reaction-2",
+		},
+		{
+			source: "https://brid.gy/comment/reddit/joe/abc/def",
+			value: `<article class="h-entry">
+				<a class="p-author h-card" href="https://www.reddit.com/user/dan"></a>
+				<a class="u-url" href="https://www.reddit.com/r/golang/comments/abc/x/def/"></a>
+				<a class="u-in-reply-to" href="https://bitworking.org/bar"></a>
+			</article>`,
+			silo:        SILO_REDDIT,
+			typ:         TYPE_REPLY,
+			author:      "u/dan",
+			originalURL: "https://www.reddit.com/r/golang/comments/abc/x/def/",
+		},
+		{
+			// A Mastodon post whose URL findSyndication doesn't recognize.
+			source: "https://brid.gy/repost/mastodon/@joe@mastodon.social/123/456",
+			value: `<article class="h-entry">
+				<a class="p-author h-card" href="https://social.example/@gina"></a>
+				<a class="u-url" href="https://social.example/notes/456"></a>
+				<a class="u-repost-of" href="https://bitworking.org/bar"></a>
+			</article>`,
+			silo:        SILO_MASTODON,
+			typ:         TYPE_REPOST,
+			author:      "@gina@social.example",
+			originalURL: "https://social.example/notes/456",
+		},
+		{
+			// An original URL that isn't on the silo is dropped.
+			source: "https://brid.gy/like/flickr/joe/1/2",
+			value: `<article class="h-entry">
+				<a class="p-author h-card" href="https://www.flickr.com/people/frank"></a>
+				<a class="u-url" href="https://evil.example.com/"></a>
+				<a class="u-like-of" href="https://bitworking.org/bar"></a>
+			</article>`,
+			silo:   SILO_FLICKR,
+			typ:    TYPE_LIKE,
+			author: "frank",
+		},
+		{
+			// Not from a silo we know about.
+			source: "https://brid.gy/like/instagram/joe/1/2",
+			value: `<article class="h-entry">
+				<span class="p-name">tag:instagram.com,2013:1</span>
+				<a class="u-like-of" href="https://bitworking.org/bar"></a>
+			</article>`,
+			typ:   TYPE_LIKE,
+			title: "tag:instagram.com,2013:1",
+		},
+		{
+			// Not from Bridgy.
+			source: "https://example.org/like/twitter/joe/1/2",
+			value: `<article class="h-entry">
+				<span class="p-name">A post</span>
+				<a class="u-url" href="https://example.org/like/twitter/joe/1/2"></a>
+				<a href="https://bitworking.org/bar"></a>
+			</article>`,
+			typ:   TYPE_MENTION,
+			title: "A post",
+		},
+	}
+	noFetch := func(u string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("Not fetched in tests.")
+	}
+	m := InitForTesting(t)
+	for _, tc := range testCases {
+		u, err := url.Parse(tc.source)
+		assert.NoError(t, err)
+		data := microformats.Parse(strings.NewReader(tc.value), u)
+		mention := New(tc.source, "https://bitworking.org/bar")
+		mention.Type = TYPE_MENTION
+		m.findHEntry(context.Background(), noFetch, mention, data, data.Items)
+		assert.Equal(t, tc.silo, mention.Silo, tc.source)
+		assert.Equal(t, tc.typ, mention.Type, tc.source)
+		assert.Equal(t, tc.author, mention.Author, tc.source)
+		assert.Equal(t, tc.originalURL, mention.OriginalURL, tc.source)
+		assert.Equal(t, tc.title, mention.Title, tc.source)
+	}
+}
+
+func TestViaSilo(t *testing.T) {
+	m := &Mention{
+		Silo:        SILO_MASTODON,
+		OriginalURL: "https://example.com/not/a/status",
+	}
+	assert.Equal(t, "Mastodon", m.Via())
+}
@@ -0,0 +1,193 @@
+package mention
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+
+	"willnorris.com/go/webmention"
+)
+
+// isHTML returns true if mediaType is HTML, which is the only kind of source
+// that's parsed for microformats.
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// isJSON returns true if mediaType is JSON, which includes JF2 and
+// ActivityStreams 2.0 documents.
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// isFeed returns true if mediaType is an Atom or RSS feed, or XML that might
+// be one.
+func isFeed(mediaType string) bool {
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// linksTo returns true if the source document b, of type mediaType, mentions
+// target, comparing URLs as normalized by policy.
+//
+// HTML must link to the target. Plain text must contain the target URL,
+// JSON must have a string value that contains it, and Atom and RSS must have
+// an entry that links to it.
+func linksTo(policy URLPolicy, mediaType string, b []byte, source, target string) (bool, error) {
+	target, err := policy.Normalize(target)
+	if err != nil {
+		return false, err
+	}
+	match := func(u string) bool {
+		normalized, err := policy.Normalize(u)
+		return err == nil && normalized == target
+	}
+	switch {
+	case isHTML(mediaType):
+		return htmlLinksTo(bytes.NewReader(b), source, match)
+	case mediaType == "text/plain":
+		return textLinksTo(string(b), match), nil
+	case isJSON(mediaType):
+		return jsonLinksTo(b, match)
+	case isFeed(mediaType):
+		return feedLinksTo(b, source, match)
+	}
+	return false, nil
+}
+
+func htmlLinksTo(r io.Reader, source string, match func(string) bool) (bool, error) {
+	links, err := webmention.DiscoverLinksFromReader(r, source, "")
+	if err != nil {
+		return false, err
+	}
+	for _, link := range links {
+		if match(link) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// textLinksTo returns true if any of the URLs in s match.
+func textLinksTo(s string, match func(string) bool) bool {
+	for _, u := range findURLs(s) {
+		if match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonLinksTo returns true if any string value in the JSON document b
+// contains a URL that matches. That covers a plain "url": target, as well as
+// links in the HTML content of JF2 and ActivityStreams 2.0 documents.
+func jsonLinksTo(b []byte, match func(string) bool) (bool, error) {
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return false, err
+	}
+	return jsonValueLinksTo(doc, match), nil
+}
+
+func jsonValueLinksTo(value interface{}, match func(string) bool) bool {
+	switch v := value.(type) {
+	case string:
+		return textLinksTo(v, match)
+	case []interface{}:
+		for _, item := range v {
+			if jsonValueLinksTo(item, match) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if jsonValueLinksTo(item, match) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// feedContent is any element of an Atom entry or RSS item that can hold
+// HTML, either escaped or, for Atom's type="xhtml", inline.
+type feedContent struct {
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+// feedLinksTo returns true if an entry of the Atom or RSS feed b has a link
+// that matches, either a link element or a link within its content.
+func feedLinksTo(b []byte, source string, match func(string) bool) (bool, error) {
+	base, err := url.Parse(source)
+	if err != nil {
+		return false, err
+	}
+	resolve := func(ref string) string {
+		u, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil {
+			return ""
+		}
+		return u.String()
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(b))
+	// Feeds are often in some other charset, but URLs are ASCII, so read them
+	// as they are.
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			if name == "entry" || name == "item" {
+				depth++
+				continue
+			}
+			if depth == 0 {
+				continue
+			}
+			switch name {
+			case "link":
+				// Atom has the URL in href, RSS has it as the element's text.
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "href" && match(resolve(attr.Value)) {
+						return true, nil
+					}
+				}
+				var text string
+				if err := d.DecodeElement(&text, &t); err != nil {
+					return false, err
+				}
+				if text != "" && match(resolve(text)) {
+					return true, nil
+				}
+			case "content", "summary", "description", "encoded":
+				var content feedContent
+				if err := d.DecodeElement(&content, &t); err != nil {
+					return false, err
+				}
+				for _, html := range []string{content.Text, content.Inner} {
+					if found, err := htmlLinksTo(strings.NewReader(html), source, match); err == nil && found {
+						return true, nil
+					}
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "entry" || t.Name.Local == "item" {
+				depth--
+			}
+		}
+	}
+}
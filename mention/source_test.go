@@ -0,0 +1,69 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSource = "https://example.org/post"
+	testTarget = "https://bitworking.org/bar"
+)
+
+func TestLinksTo(t *testing.T) {
+	testCases := []struct {
+		name      string
+		mediaType string
+		body      string
+		found     bool
+	}{
+		{"html", "text/html", `<a href="https://bitworking.org/bar">Bar</a>`, true},
+		{"html relative", "text/html", `<a href="/bar">Bar</a>`, false},
+		{"html variant", "text/html", `<a href="http://www.bitworking.org/bar/#comments">Bar</a>`, true},
+		{"text", "text/plain", "Replying to https://bitworking.org/bar.", true},
+		{"text longer url", "text/plain", "See https://bitworking.org/barn", false},
+		{"text missing", "text/plain", "Nothing to see here.", false},
+		{"json", "application/json", `{"links": [{"url": "https://bitworking.org/bar"}]}`, true},
+		{"jf2", "application/jf2+json", `{"type": "entry", "in-reply-to": "https://bitworking.org/bar"}`, true},
+		{"jf2 content", "application/jf2+json", `{"type": "entry", "content": {"html": "<a href=\"https://bitworking.org/bar\">Bar</a>"}}`, true},
+		{"as2", "application/activity+json", `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Note", "inReplyTo": "https://bitworking.org/bar"}`, true},
+		{"json missing", "application/json", `{"url": "https://bitworking.org/barn", "n": 1}`, false},
+		{"atom link", "application/atom+xml", `<feed xmlns="http://www.w3.org/2005/Atom"><entry><link href="https://bitworking.org/bar"/></entry></feed>`, true},
+		{"atom relative link", "application/atom+xml", `<feed xmlns="http://www.w3.org/2005/Atom"><entry><link href="https://bitworking.org/"/><link rel="related" href="//bitworking.org/bar"/></entry></feed>`, true},
+		{"atom content", "application/atom+xml", `<feed xmlns="http://www.w3.org/2005/Atom"><entry><content type="html">&lt;a href="https://bitworking.org/bar"&gt;Bar&lt;/a&gt;</content></entry></feed>`, true},
+		{"atom xhtml content", "application/atom+xml", `<feed xmlns="http://www.w3.org/2005/Atom"><entry><content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><a href="https://bitworking.org/bar">Bar</a></div></content></entry></feed>`, true},
+		{"atom feed link", "application/atom+xml", `<feed xmlns="http://www.w3.org/2005/Atom"><link href="https://bitworking.org/bar"/><entry></entry></feed>`, false},
+		{"rss link", "application/rss+xml", `<rss><channel><item><link>https://bitworking.org/bar</link></item></channel></rss>`, true},
+		{"rss description", "application/rss+xml", `<rss><channel><item><description><![CDATA[<a href="https://bitworking.org/bar">Bar</a>]]></description></item></channel></rss>`, true},
+		{"rss missing", "text/xml", `<rss><channel><link>https://bitworking.org/bar</link><item></item></channel></rss>`, false},
+	}
+	for _, tc := range testCases {
+		found, err := linksTo(URLPolicy{}, tc.mediaType, []byte(tc.body), testSource, testTarget)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.found, found, tc.name)
+	}
+}
+
+func TestLinksToInvalid(t *testing.T) {
+	_, err := linksTo(URLPolicy{}, "application/json", []byte(`{"url": `), testSource, testTarget)
+	assert.Error(t, err)
+	_, err = linksTo(URLPolicy{}, "application/atom+xml", []byte(`<feed><entry>`), testSource, testTarget)
+	assert.Error(t, err)
+}
+
+func TestSlowValidateJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/jf2+json")
+		fmt.Fprint(w, `{"type": "entry", "like-of": "https://bitworking.org/bar"}`)
+	}))
+	defer ts.Close()
+
+	m := InitForTesting(t)
+	mention := New(ts.URL, testTarget)
+	assert.NoError(t, m.SlowValidate(context.Background(), mention, ts.Client()))
+}
@@ -0,0 +1,86 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when the requested item doesn't exist.
+var ErrNotFound = fmt.Errorf("Not found.")
+
+// Query describes a set of mentions to retrieve from a Store.
+type Query struct {
+	// Target, if not empty, restricts the results to mentions of this target.
+	Target string
+
+	// State, if not empty, restricts the results to mentions in this state.
+	State string
+
+	// Type, if not empty, restricts the results to mentions of this type.
+	Type string
+
+	// DueBefore, if not zero, restricts the results to mentions whose
+	// NextAttempt is set, and is at or before this time.
+	DueBefore time.Time
+
+	// VerifiedBefore, if not zero, restricts the results to mentions whose
+	// LastVerified is before this time, and orders them by LastVerified,
+	// oldest first, and then by key. Can't be combined with NewestFirst.
+	VerifiedBefore time.Time
+
+	// NewestFirst orders the results by descending TS, and then by descending
+	// key.
+	NewestFirst bool
+
+	// StartAfter, if not empty, is the key of the last mention on the previous
+	// page of NewestFirst results. Only mentions that come after it are
+	// returned. Unlike Offset this doesn't need to skip over all the earlier
+	// results.
+	StartAfter string
+
+	// Limit, if greater than zero, is the maximum number of results.
+	Limit int
+
+	// Offset is the number of results to skip.
+	Offset int
+}
+
+// Store is the persistence layer for Mentions.
+//
+// It holds mentions, records of sent webmentions, and thumbnails.
+type Store interface {
+	// GetMention returns the mention stored under key, or ErrNotFound.
+	GetMention(ctx context.Context, key string) (*Mention, error)
+
+	// PutMention writes the mention under key, replacing any existing one.
+	PutMention(ctx context.Context, key string, mention *Mention) error
+
+	// UpdateMention reads the mention stored under key, calls f on it, and
+	// writes it back, all in a single transaction. If f returns an error then
+	// nothing is written.
+	UpdateMention(ctx context.Context, key string, f func(*Mention) error) error
+
+	// QueryMentions returns all the mentions that match q.
+	QueryMentions(ctx context.Context, q *Query) ([]*MentionWithKey, error)
+
+	// GetSent returns the time a webmention was last sent for source, or
+	// ErrNotFound.
+	GetSent(ctx context.Context, source string) (time.Time, error)
+
+	// PutSent records the time a webmention was sent for source.
+	PutSent(ctx context.Context, source string, ts time.Time) error
+
+	// GetThumbnail returns the PNG thumbnail with the given id, or ErrNotFound.
+	GetThumbnail(ctx context.Context, id string) ([]byte, error)
+
+	// PutThumbnail stores a PNG thumbnail under the given id.
+	PutThumbnail(ctx context.Context, id string, png []byte) error
+
+	// GetAuthorProfile returns the profile cached for the author page u, or
+	// ErrNotFound. Expired profiles are returned too.
+	GetAuthorProfile(ctx context.Context, u string) (*AuthorProfile, error)
+
+	// PutAuthorProfile caches the profile for the author page u.
+	PutAuthorProfile(ctx context.Context, u string, profile *AuthorProfile) error
+}
@@ -0,0 +1,16 @@
+package mention_test
+
+import (
+	"testing"
+
+	"github.com/jcgregorio/webmention-func/mention"
+	"github.com/jcgregorio/webmention-func/mention/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.TestStore(t, mention.NewMemoryStore())
+}
+
+func TestDatastoreStore(t *testing.T) {
+	storetest.TestStore(t, mention.InitDatastoreForTesting(t))
+}
@@ -0,0 +1,263 @@
+// storetest is a package of tests that every mention.Store implementation
+// should pass.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/webmention-func/mention"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStore runs all the Store tests against s, which must be empty.
+func TestStore(t *testing.T, s mention.Store) {
+	testMentions(t, s)
+	testUpdateMention(t, s)
+	testVerifiedBefore(t, s)
+	testSent(t, s)
+	testThumbnails(t, s)
+	testAuthorProfiles(t, s)
+}
+
+func testMentions(t *testing.T, s mention.Store) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	_, err := s.GetMention(ctx, "missing")
+	assert.Equal(t, mention.ErrNotFound, err)
+
+	for i, state := range []string{mention.GOOD_STATE, mention.SPAM_STATE, mention.GOOD_STATE, mention.UNTRIAGED_STATE} {
+		m := &mention.Mention{
+			Source: fmt.Sprintf("https://example.com/%d", i),
+			Target: "https://bitworking.org/bar",
+			State:  state,
+			TS:     now.Add(time.Duration(i) * time.Minute),
+		}
+		assert.NoError(t, s.PutMention(ctx, fmt.Sprintf("key%d", i), m))
+	}
+	assert.NoError(t, s.PutMention(ctx, "other", &mention.Mention{
+		Source: "https://example.com/other",
+		Target: "https://bitworking.org/other",
+		State:  mention.GOOD_STATE,
+		TS:     now.Add(-time.Hour),
+	}))
+
+	m, err := s.GetMention(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/1", m.Source)
+	assert.Equal(t, mention.SPAM_STATE, m.State)
+	assert.True(t, now.Add(time.Minute).Equal(m.TS))
+
+	found, err := s.QueryMentions(ctx, &mention.Query{Target: "https://bitworking.org/bar"})
+	assert.NoError(t, err)
+	assert.Len(t, found, 4)
+
+	found, err = s.QueryMentions(ctx, &mention.Query{Target: "https://bitworking.org/bar", State: mention.GOOD_STATE})
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	found, err = s.QueryMentions(ctx, &mention.Query{State: mention.UNTRIAGED_STATE})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "key3", found[0].Key)
+
+	found, err = s.QueryMentions(ctx, &mention.Query{NewestFirst: true, Limit: 2, Offset: 1})
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+	assert.Equal(t, "key2", found[0].Key)
+	assert.Equal(t, "key1", found[1].Key)
+
+	found, err = s.QueryMentions(ctx, &mention.Query{NewestFirst: true, StartAfter: "key2", Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+	assert.Equal(t, "key1", found[0].Key)
+	assert.Equal(t, "key0", found[1].Key)
+
+	// Mentions with the same TS are ordered by key.
+	for _, key := range []string{"same1", "same2", "same3"} {
+		assert.NoError(t, s.PutMention(ctx, key, &mention.Mention{
+			Source: "https://example.com/" + key,
+			Target: "https://bitworking.org/same",
+			State:  mention.GOOD_STATE,
+			TS:     now.Add(time.Hour),
+		}))
+	}
+	found, err = s.QueryMentions(ctx, &mention.Query{NewestFirst: true, StartAfter: "same2", Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+	assert.Equal(t, "same1", found[0].Key)
+	assert.Equal(t, "key3", found[1].Key)
+
+	// Only mentions whose NextAttempt has passed are due.
+	assert.NoError(t, s.PutMention(ctx, "retry-due", &mention.Mention{
+		Source:      "https://example.com/retry-due",
+		Target:      "https://bitworking.org/retry",
+		State:       mention.RETRY_STATE,
+		TS:          now,
+		NextAttempt: now.Add(-time.Minute),
+	}))
+	assert.NoError(t, s.PutMention(ctx, "retry-later", &mention.Mention{
+		Source:      "https://example.com/retry-later",
+		Target:      "https://bitworking.org/retry",
+		State:       mention.RETRY_STATE,
+		TS:          now,
+		NextAttempt: now.Add(time.Minute),
+	}))
+	found, err = s.QueryMentions(ctx, &mention.Query{State: mention.RETRY_STATE, DueBefore: now})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "retry-due", found[0].Key)
+
+	// Mentions without a NextAttempt are never due.
+	found, err = s.QueryMentions(ctx, &mention.Query{State: mention.UNTRIAGED_STATE, DueBefore: now})
+	assert.NoError(t, err)
+	assert.Len(t, found, 0)
+
+	// By type.
+	for _, typ := range []string{mention.TYPE_LIKE, mention.TYPE_REPLY} {
+		assert.NoError(t, s.PutMention(ctx, typ, &mention.Mention{
+			Source: "https://example.com/" + typ,
+			Target: "https://bitworking.org/typed",
+			State:  mention.GOOD_STATE,
+			Type:   typ,
+			TS:     now,
+		}))
+	}
+	found, err = s.QueryMentions(ctx, &mention.Query{Target: "https://bitworking.org/typed", State: mention.GOOD_STATE, Type: mention.TYPE_LIKE})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, mention.TYPE_LIKE, found[0].Key)
+
+	// Overwrite.
+	m.State = mention.GOOD_STATE
+	assert.NoError(t, s.PutMention(ctx, "key1", m))
+	m, err = s.GetMention(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, mention.GOOD_STATE, m.State)
+}
+
+func testUpdateMention(t *testing.T, s mention.Store) {
+	ctx := context.Background()
+	assert.NoError(t, s.PutMention(ctx, "update", &mention.Mention{
+		Source: "https://example.com/update",
+		Target: "https://bitworking.org/update",
+		State:  mention.UNTRIAGED_STATE,
+		TS:     time.Now(),
+	}))
+
+	err := s.UpdateMention(ctx, "update", func(m *mention.Mention) error {
+		m.State = mention.SPAM_STATE
+		return nil
+	})
+	assert.NoError(t, err)
+	m, err := s.GetMention(ctx, "update")
+	assert.NoError(t, err)
+	assert.Equal(t, mention.SPAM_STATE, m.State)
+
+	// An error from f means nothing gets written.
+	err = s.UpdateMention(ctx, "update", func(m *mention.Mention) error {
+		m.State = mention.GOOD_STATE
+		return fmt.Errorf("Abort.")
+	})
+	assert.Error(t, err)
+	m, err = s.GetMention(ctx, "update")
+	assert.NoError(t, err)
+	assert.Equal(t, mention.SPAM_STATE, m.State)
+
+	err = s.UpdateMention(ctx, "missing", func(m *mention.Mention) error {
+		return nil
+	})
+	assert.Equal(t, mention.ErrNotFound, err)
+}
+
+func testVerifiedBefore(t *testing.T, s mention.Store) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	// DELETED_STATE isn't used by the other tests, so only these mentions are
+	// in it.
+	for key, lastVerified := range map[string]time.Time{
+		"verified-never":  {},
+		"verified-old":    now.Add(-48 * time.Hour),
+		"verified-older":  now.Add(-72 * time.Hour),
+		"verified-recent": now.Add(-time.Minute),
+	} {
+		assert.NoError(t, s.PutMention(ctx, key, &mention.Mention{
+			Source:       "https://example.com/" + key,
+			Target:       "https://bitworking.org/verified",
+			State:        mention.DELETED_STATE,
+			TS:           now,
+			LastVerified: lastVerified,
+		}))
+	}
+	found, err := s.QueryMentions(ctx, &mention.Query{
+		State:          mention.DELETED_STATE,
+		VerifiedBefore: now.Add(-time.Hour),
+		Limit:          2,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, found, 2) {
+		assert.Equal(t, "verified-never", found[0].Key)
+		assert.Equal(t, "verified-older", found[1].Key)
+	}
+	found, err = s.QueryMentions(ctx, &mention.Query{
+		State:          mention.DELETED_STATE,
+		VerifiedBefore: now.Add(-time.Hour),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, found, 3)
+}
+
+func testSent(t *testing.T, s mention.Store) {
+	ctx := context.Background()
+	_, err := s.GetSent(ctx, "https://bitworking.org/news/1")
+	assert.Equal(t, mention.ErrNotFound, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	assert.NoError(t, s.PutSent(ctx, "https://bitworking.org/news/1", now))
+	ts, err := s.GetSent(ctx, "https://bitworking.org/news/1")
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(ts))
+}
+
+func testAuthorProfiles(t *testing.T, s mention.Store) {
+	ctx := context.Background()
+	_, err := s.GetAuthorProfile(ctx, "https://alice.example.com/")
+	assert.Equal(t, mention.ErrNotFound, err)
+
+	expires := time.Now().UTC().Truncate(time.Second)
+	profile := &mention.AuthorProfile{
+		URL:       "https://alice.example.com/",
+		Name:      "Alice",
+		Photo:     "https://alice.example.com/photo.jpg",
+		Thumbnail: "abc",
+		Expires:   expires,
+	}
+	assert.NoError(t, s.PutAuthorProfile(ctx, "https://alice.example.com/", profile))
+	found, err := s.GetAuthorProfile(ctx, "https://alice.example.com/")
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", found.Name)
+	assert.Equal(t, "abc", found.Thumbnail)
+	assert.True(t, expires.Equal(found.Expires))
+
+	// Overwrite.
+	profile.Name = "Alice Example"
+	assert.NoError(t, s.PutAuthorProfile(ctx, "https://alice.example.com/", profile))
+	found, err = s.GetAuthorProfile(ctx, "https://alice.example.com/")
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice Example", found.Name)
+}
+
+func testThumbnails(t *testing.T, s mention.Store) {
+	ctx := context.Background()
+	_, err := s.GetThumbnail(ctx, "abc")
+	assert.Equal(t, mention.ErrNotFound, err)
+
+	assert.NoError(t, s.PutThumbnail(ctx, "abc", []byte("png")))
+	b, err := s.GetThumbnail(ctx, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("png"), b)
+}
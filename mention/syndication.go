@@ -0,0 +1,136 @@
+package mention
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"willnorris.com/go/microformats"
+)
+
+// silos maps the hosts of silos to their names, for Mention.Via.
+var silos = map[string]string{
+	"twitter.com":   "Twitter",
+	"x.com":         "Twitter",
+	"github.com":    "GitHub",
+	"reddit.com":    "Reddit",
+	"flickr.com":    "Flickr",
+	"facebook.com":  "Facebook",
+	"instagram.com": "Instagram",
+	"bsky.app":      "Bluesky",
+}
+
+// mastodonStatus matches the paths of Mastodon posts, which can be on any
+// host, i.e. "/@alice/1234" or "/users/alice/statuses/1234".
+var mastodonStatus = regexp.MustCompile(`^/(@[^/]+|users/[^/]+/statuses)/\d+/?$`)
+
+// findSyndication sets OriginalURL and Syndication on mention from the h-entry
+// it.
+//
+// OriginalURL is only set if the h-entry's url is a post on a silo, since an
+// ordinary post's url is usually its own permalink, or canonical URL, rather
+// than a sign that it's a copy. The SiloAdapters find the originals of the
+// copies that Bridgy makes of posts on other hosts, i.e. Mastodon instances.
+func findSyndication(policy URLPolicy, mention *Mention, it *microformats.Microformat) {
+	for _, u := range propURLs(it, "url") {
+		if isAbsoluteURL(u) && !policy.Equal(u, mention.Source) && siloName(u) != "" {
+			mention.OriginalURL = u
+			break
+		}
+	}
+	mention.Syndication = []string{}
+	for _, u := range propURLs(it, "syndication") {
+		if !isAbsoluteURL(u) || policy.Equal(u, mention.Source) || in(u, mention.Syndication) {
+			continue
+		}
+		mention.Syndication = append(mention.Syndication, u)
+	}
+}
+
+// Via returns the name of the silo a bridged mention was copied from, i.e.
+// "Mastodon", or its host if it isn't a silo we know about. Returns "" if the
+// mention wasn't bridged.
+func (m *Mention) Via() string {
+	if name, ok := siloNames[m.Silo]; ok {
+		return name
+	}
+	if m.OriginalURL == "" {
+		return ""
+	}
+	if name := siloName(m.OriginalURL); name != "" {
+		return name
+	}
+	u, err := url.Parse(m.OriginalURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// siloName returns the name of the silo that the post at u is on, i.e.
+// "Mastodon", or "" if it isn't on one we know about.
+func siloName(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	if name, ok := silos[host]; ok {
+		return name
+	}
+	if mastodonStatus.MatchString(parsed.Path) {
+		return "Mastodon"
+	}
+	return ""
+}
+
+// dedupe returns mentions without the duplicates of the same post, i.e. a
+// reply that arrived both from its author's site and from a bridge, or from
+// several bridges. Mentions are duplicates if their sources, original URLs
+// or syndication URLs overlap. The mention that wasn't bridged is kept, or
+// else the first one received.
+func dedupe(policy URLPolicy, mentions []*Mention) []*Mention {
+	preferred := make([]*Mention, len(mentions))
+	copy(preferred, mentions)
+	sort.SliceStable(preferred, func(i, j int) bool {
+		if (preferred[i].OriginalURL == "") != (preferred[j].OriginalURL == "") {
+			return preferred[i].OriginalURL == ""
+		}
+		return preferred[i].TS.Before(preferred[j].TS)
+	})
+	seen := map[string]bool{}
+	keep := map[*Mention]bool{}
+	for _, m := range preferred {
+		urls := append([]string{m.Source, m.OriginalURL}, m.Syndication...)
+		normalized := []string{}
+		duplicate := false
+		for _, u := range urls {
+			if u == "" {
+				continue
+			}
+			if n, err := policy.Normalize(u); err == nil {
+				u = n
+			}
+			if seen[u] {
+				duplicate = true
+			}
+			normalized = append(normalized, u)
+		}
+		// The URLs of duplicates are recorded too, so that a chain of
+		// overlapping mentions is reduced to one.
+		for _, u := range normalized {
+			seen[u] = true
+		}
+		if !duplicate {
+			keep[m] = true
+		}
+	}
+	ret := []*Mention{}
+	for _, m := range mentions {
+		if keep[m] {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
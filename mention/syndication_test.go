@@ -0,0 +1,106 @@
+package mention
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"willnorris.com/go/microformats"
+)
+
+func TestFindSyndication(t *testing.T) {
+	raw := `<div class="h-entry">
+		<a class="u-url" href="https://mastodon.social/@alice/1234">Permalink</a>
+		<a class="u-syndication" href="https://twitter.com/alice/status/5678">Twitter</a>
+		<a class="u-syndication" href="https://twitter.com/alice/status/5678">Twitter</a>
+		<a class="u-in-reply-to" href="https://bitworking.org/bar">Bar</a>
+	</div>`
+	source := "https://brid.gy/comment/mastodon/@alice@mastodon.social/1/1234"
+	u, err := url.Parse(source)
+	assert.NoError(t, err)
+	data := microformats.Parse(strings.NewReader(raw), u)
+	mention := New(source, "https://bitworking.org/bar")
+	findSyndication(URLPolicy{}, mention, data.Items[0])
+	assert.Equal(t, "https://mastodon.social/@alice/1234", mention.OriginalURL)
+	assert.Equal(t, []string{"https://twitter.com/alice/status/5678"}, mention.Syndication)
+
+	// A post's u-url that's its own source isn't an original.
+	mention = New("https://mastodon.social/@alice/1234", "https://bitworking.org/bar")
+	findSyndication(URLPolicy{}, mention, data.Items[0])
+	assert.Equal(t, "", mention.OriginalURL)
+
+	// Nor is an ordinary post's permalink, or canonical URL.
+	for _, permalink := range []string{
+		"https://alice.example.com/2019/01/reply",
+		"https://alice.example.com/reply?utm_source=feed",
+		"http://alice.example.com/reply",
+		"https://alice.example/r/1",
+	} {
+		raw := `<div class="h-entry"><a class="u-url" href="` + permalink + `">Permalink</a></div>`
+		data := microformats.Parse(strings.NewReader(raw), u)
+		mention = New("https://alice.example.com/reply", "https://bitworking.org/bar")
+		findSyndication(URLPolicy{}, mention, data.Items[0])
+		assert.Equal(t, "", mention.OriginalURL, permalink)
+		assert.Equal(t, "", mention.Via(), permalink)
+	}
+}
+
+func TestVia(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected string
+	}{
+		{"", ""},
+		{"https://mastodon.social/@alice/1234", "Mastodon"},
+		{"https://hachyderm.io/users/alice/statuses/1234", "Mastodon"},
+		{"https://twitter.com/alice/status/5678", "Twitter"},
+		{"https://www.reddit.com/r/golang/comments/abc/", "Reddit"},
+		{"https://github.com/jcgregorio/webmention-func/issues/1", "GitHub"},
+		{"https://example.com/some/post", "example.com"},
+	}
+	for _, tc := range testCases {
+		m := &Mention{OriginalURL: tc.value}
+		assert.Equal(t, tc.expected, m.Via(), tc.value)
+	}
+}
+
+func TestGetGoodDedupesBridgedCopies(t *testing.T) {
+	ctx := context.Background()
+	m := InitForTesting(t)
+	now := time.Now()
+	put := func(source, original string, syndication []string, ts time.Time) {
+		mention := New(source, "https://bitworking.org/bar")
+		mention.State = GOOD_STATE
+		mention.OriginalURL = original
+		mention.Syndication = syndication
+		mention.TS = ts
+		assert.NoError(t, m.Put(ctx, mention))
+	}
+	// A reply from Alice's site, that's also on Mastodon.
+	put("https://alice.example.com/reply", "", []string{"https://mastodon.social/@alice/1234"}, now)
+	// The same reply, bridged from Mastodon, by two different bridges.
+	put("https://brid.gy/comment/mastodon/@alice@mastodon.social/1/1234", "https://mastodon.social/@alice/1234", nil, now.Add(-time.Minute))
+	put("https://fed.brid.gy/r/https://mastodon.social/@alice/1234", "https://mastodon.social/@alice/1234", nil, now.Add(-2*time.Minute))
+	// A like from Bob, only on Twitter, bridged twice.
+	put("https://brid.gy/like/twitter/bob/1/2", "https://twitter.com/bob/status/2", nil, now.Add(-time.Minute))
+	put("https://other-bridge.example/like/2", "https://twitter.com/bob/status/2", nil, now)
+	// Something else entirely.
+	put("https://carol.example.com/post", "", nil, now)
+
+	good := m.GetGood(ctx, "https://bitworking.org/bar")
+	sources := []string{}
+	for _, g := range good {
+		sources = append(sources, g.Source)
+	}
+	assert.ElementsMatch(t, []string{
+		"https://alice.example.com/reply",
+		"https://brid.gy/like/twitter/bob/1/2",
+		"https://carol.example.com/post",
+	}, sources)
+
+	// GetAll still has them all, for triage.
+	assert.Len(t, m.GetAll(ctx, "https://bitworking.org/bar"), 6)
+}
@@ -0,0 +1,233 @@
+package mention
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DEFAULT_TARGET_CACHE_DURATION is the default for
+	// TargetChecker.CacheDuration.
+	DEFAULT_TARGET_CACHE_DURATION = time.Hour
+
+	// DEFAULT_MISSING_TARGET_CACHE_DURATION is the default for
+	// TargetChecker.MissingCacheDuration.
+	DEFAULT_MISSING_TARGET_CACHE_DURATION = time.Minute
+
+	// MAX_SITEMAP_BYTES is the largest sitemap that will be read.
+	MAX_SITEMAP_BYTES = 10 << 20
+
+	// MAX_SITEMAPS is the most sitemaps that will be read from a sitemap
+	// index.
+	MAX_SITEMAPS = 50
+
+	// maxCachedTargets is the number of cached answers above which expired
+	// ones are dropped.
+	maxCachedTargets = 10000
+)
+
+// TargetChecker checks that the targets of mentions exist on our own site,
+// either by requesting them, or by looking them up in the site's sitemap.
+//
+// Answers are cached, so a target that gets mentioned a lot is only checked
+// every so often.
+type TargetChecker struct {
+	// Sitemap is the URL of the site's sitemap, or sitemap index. If empty
+	// then each target is requested instead, with HEAD, or with GET if the
+	// site doesn't support HEAD.
+	Sitemap string
+
+	// CacheDuration is how long a target that exists is remembered.
+	CacheDuration time.Duration
+
+	// MissingCacheDuration is how long a target that doesn't exist is
+	// remembered, which is also how often the sitemap is reloaded. Keep it
+	// short so that mentions of a newly published page are accepted.
+	MissingCacheDuration time.Duration
+
+	client *http.Client
+	policy URLPolicy
+
+	mutex   sync.Mutex
+	cache   map[string]targetAnswer
+	pages   map[string]bool
+	expires time.Time
+}
+
+type targetAnswer struct {
+	exists  bool
+	expires time.Time
+}
+
+// NewTargetChecker returns a TargetChecker that uses c to request targets, or
+// the sitemap if it isn't empty, and compares URLs as normalized by policy.
+func NewTargetChecker(c *http.Client, sitemap string, policy URLPolicy) *TargetChecker {
+	return &TargetChecker{
+		Sitemap:              sitemap,
+		CacheDuration:        DEFAULT_TARGET_CACHE_DURATION,
+		MissingCacheDuration: DEFAULT_MISSING_TARGET_CACHE_DURATION,
+		client:               c,
+		policy:               policy,
+		cache:                map[string]targetAnswer{},
+	}
+}
+
+// Exists returns true if target is a page on our site. An error is returned
+// if that can't be determined, i.e. the site is down.
+func (t *TargetChecker) Exists(ctx context.Context, target string) (bool, error) {
+	target, err := t.policy.Normalize(target)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	t.mutex.Lock()
+	answer, ok := t.cache[target]
+	t.mutex.Unlock()
+	if ok && now.Before(answer.expires) {
+		return answer.exists, nil
+	}
+
+	var exists bool
+	if t.Sitemap != "" {
+		exists, err = t.inSitemap(ctx, target, now)
+	} else {
+		exists, err = t.request(ctx, target)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	answer = targetAnswer{
+		exists:  exists,
+		expires: now.Add(t.MissingCacheDuration),
+	}
+	if exists {
+		answer.expires = now.Add(t.CacheDuration)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.cache) >= maxCachedTargets {
+		for key, cached := range t.cache {
+			if !now.Before(cached.expires) {
+				delete(t.cache, key)
+			}
+		}
+	}
+	t.cache[target] = answer
+	return exists, nil
+}
+
+// request returns true if target responds with a 2xx, after any redirects.
+func (t *TargetChecker) request(ctx context.Context, target string) (bool, error) {
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			return false, fmt.Errorf("Invalid target: %s", err)
+		}
+		resp, err := t.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return false, fmt.Errorf("Failed to request target: %s", err)
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+			return true, nil
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+			return false, nil
+		case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+			// Try again with GET.
+			continue
+		default:
+			return false, fmt.Errorf("Target responded with status %d.", resp.StatusCode)
+		}
+	}
+	return false, fmt.Errorf("Target doesn't support HEAD or GET.")
+}
+
+// inSitemap returns true if target is listed in the sitemap, which is loaded
+// again if it's older than MissingCacheDuration.
+func (t *TargetChecker) inSitemap(ctx context.Context, target string, now time.Time) (bool, error) {
+	t.mutex.Lock()
+	pages, expires := t.pages, t.expires
+	t.mutex.Unlock()
+	if pages == nil || !now.Before(expires) {
+		var err error
+		pages, err = t.loadSitemap(ctx)
+		if err != nil {
+			return false, err
+		}
+		t.mutex.Lock()
+		t.pages = pages
+		t.expires = now.Add(t.MissingCacheDuration)
+		t.mutex.Unlock()
+	}
+	return pages[target], nil
+}
+
+// sitemap is either a sitemap, which lists pages, or a sitemap index, which
+// lists other sitemaps. See https://www.sitemaps.org/protocol.html.
+type sitemap struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// loadSitemap returns the set of normalized URLs of all the pages in the
+// sitemap, following a sitemap index one level down.
+func (t *TargetChecker) loadSitemap(ctx context.Context) (map[string]bool, error) {
+	root, err := t.fetchSitemap(ctx, t.Sitemap)
+	if err != nil {
+		return nil, err
+	}
+	all := []*sitemap{root}
+	for i, child := range root.Sitemaps {
+		if i >= MAX_SITEMAPS {
+			break
+		}
+		s, err := t.fetchSitemap(ctx, child.Loc)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, s)
+	}
+	pages := map[string]bool{}
+	for _, s := range all {
+		for _, u := range s.URLs {
+			if normalized, err := t.policy.Normalize(u.Loc); err == nil {
+				pages[normalized] = true
+			}
+		}
+	}
+	return pages, nil
+}
+
+func (t *TargetChecker) fetchSitemap(ctx context.Context, u string) (*sitemap, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sitemap URL: %s", err)
+	}
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve sitemap: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Sitemap responded with status %d.", resp.StatusCode)
+	}
+	b, err := readLimited(resp.Body, MAX_SITEMAP_BYTES, "sitemap")
+	if err != nil {
+		return nil, err
+	}
+	var s sitemap
+	if err := xml.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("Failed to parse sitemap: %s", err)
+	}
+	return &s, nil
+}
@@ -0,0 +1,108 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetCheckerRequest(t *testing.T) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/exists", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/exists", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Oops", 500)
+	})
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+
+	tc := NewTargetChecker(ts.Client(), "", URLPolicy{})
+	ctx := context.Background()
+	testCases := []struct {
+		path   string
+		exists bool
+		err    bool
+	}{
+		{"/exists", true, false},
+		{"/no-head", true, false},
+		{"/moved", true, false},
+		{"/missing", false, false},
+		{"/broken", false, true},
+	}
+	for _, c := range testCases {
+		exists, err := tc.Exists(ctx, ts.URL+c.path)
+		assert.Equal(t, c.exists, exists, c.path)
+		assert.Equal(t, c.err, err != nil, c.path)
+	}
+
+	// Answers are cached.
+	requests = 0
+	exists, err := tc.Exists(ctx, ts.URL+"/exists/")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, 0, requests)
+
+	tc.CacheDuration = 0
+	tc.cache = map[string]targetAnswer{}
+	_, err = tc.Exists(ctx, ts.URL+"/exists")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests)
+}
+
+func TestTargetCheckerSitemap(t *testing.T) {
+	loads := 0
+	var ts *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		loads++
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%s/posts.xml</loc></sitemap>
+</sitemapindex>`, ts.URL)
+	})
+	mux.HandleFunc("/posts.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://bitworking.org/news/1/</loc></url>
+  <url><loc>https://bitworking.org/news/2</loc></url>
+</urlset>`)
+	})
+	ts = httptest.NewServer(mux)
+	defer ts.Close()
+
+	tc := NewTargetChecker(ts.Client(), ts.URL+"/sitemap.xml", URLPolicy{})
+	ctx := context.Background()
+	exists, err := tc.Exists(ctx, "https://bitworking.org/news/1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = tc.Exists(ctx, "http://www.bitworking.org/news/2/")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = tc.Exists(ctx, "https://bitworking.org/news/3")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, 1, loads)
+
+	// A missing target is checked again once the sitemap is stale.
+	tc.expires = time.Now().Add(-time.Second)
+	tc.cache = map[string]targetAnswer{}
+	exists, err = tc.Exists(ctx, "https://bitworking.org/news/3")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, 2, loads)
+}
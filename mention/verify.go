@@ -0,0 +1,211 @@
+package mention
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DEFAULT_WORKERS is the default for Mentions.Workers.
+	DEFAULT_WORKERS = 8
+
+	// DEFAULT_PER_HOST_LIMIT is the default for Mentions.PerHostLimit.
+	DEFAULT_PER_HOST_LIMIT = 2
+
+	// DEFAULT_VERIFY_MARGIN is how much time must be left before the deadline
+	// to start verifying another mention, if the client has no Timeout.
+	DEFAULT_VERIFY_MARGIN = 30 * time.Second
+)
+
+// hostQueues hands out the mentions to verify, one queue per source host,
+// with at most limit of each host's mentions being verified at a time. A
+// worker is only given a mention whose host has room, so a burst of mentions
+// from one host, i.e. brid.gy, doesn't leave every worker waiting on it while
+// other hosts' mentions are ready.
+type hostQueues struct {
+	limit int
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	hosts   []string
+	queues  map[string][]*Mention
+	active  map[string]int
+	next    int
+	stopped bool
+}
+
+func newHostQueues(limit int, mentions []*Mention) *hostQueues {
+	q := &hostQueues{
+		limit:  limit,
+		hosts:  []string{},
+		queues: map[string][]*Mention{},
+		active: map[string]int{},
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	for _, mention := range mentions {
+		host := sourceHost(mention)
+		if _, ok := q.queues[host]; !ok {
+			q.hosts = append(q.hosts, host)
+		}
+		q.queues[host] = append(q.queues[host], mention)
+	}
+	return q
+}
+
+// take returns the next mention from a host that has room, and its host,
+// taking from each host in turn. It waits if every host with mentions left
+// is full. Returns nil once there are no mentions left, or after stop. done
+// must be called with the host once the mention is finished with.
+func (q *hostQueues) take() (*Mention, string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for {
+		if q.stopped {
+			return nil, ""
+		}
+		left := false
+		for i := range q.hosts {
+			host := q.hosts[(q.next+i)%len(q.hosts)]
+			queue := q.queues[host]
+			if len(queue) == 0 {
+				continue
+			}
+			left = true
+			if q.active[host] >= q.limit {
+				continue
+			}
+			q.queues[host] = queue[1:]
+			q.active[host]++
+			q.next = (q.next + i + 1) % len(q.hosts)
+			return queue[0], host
+		}
+		if !left {
+			return nil, ""
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *hostQueues) done(host string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.active[host]--
+	q.cond.Broadcast()
+}
+
+// stop makes take return nil from now on.
+func (q *hostQueues) stop() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.stopped = true
+	q.cond.Broadcast()
+}
+
+func sourceHost(mention *Mention) string {
+	u, err := url.Parse(mention.Source)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// VerifyQueuedMentions verifies all the mentions that are due, using a pool
+// of Workers, with at most PerHostLimit requests to any one source host at a
+// time.
+//
+// Each mention is claimed before it's verified, so concurrent calls, even
+// from different instances, split the queue between them.
+//
+// If ctx has a deadline then no more mentions are claimed once there's less
+// time left than a single verification could take, i.e. c.Timeout. The
+// mentions that weren't started stay queued for the next run.
+func (m *Mentions) VerifyQueuedMentions(ctx context.Context, c *http.Client) {
+	queued := m.GetQueued(ctx)
+	m.log.Infof("About to slow verify %d queued mentions.", len(queued))
+
+	margin := c.Timeout
+	if margin == 0 {
+		margin = DEFAULT_VERIFY_MARGIN
+	}
+	outOfTime := func() bool {
+		deadline, ok := ctx.Deadline()
+		return ctx.Err() != nil || (ok && time.Until(deadline) < margin)
+	}
+	workers := m.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	perHost := m.PerHostLimit
+	if perHost < 1 {
+		perHost = 1
+	}
+	q := newHostQueues(perHost, queued)
+
+	// Wake up any workers waiting for a host if ctx is cancelled.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.stop()
+		case <-finished:
+		}
+	}()
+
+	var started int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				queued, host := q.take()
+				if queued == nil {
+					return
+				}
+				// Checked right before claiming, so that a mention isn't
+				// claimed once there's no time left to verify it.
+				if outOfTime() {
+					q.stop()
+					q.done(host)
+					return
+				}
+				atomic.AddInt64(&started, 1)
+				m.verifyQueued(ctx, c, queued)
+				q.done(host)
+			}
+		}()
+	}
+	wg.Wait()
+	if left := len(queued) - int(started); left > 0 {
+		m.log.Infof("Out of time, leaving %d mentions queued.", left)
+	}
+}
+
+// verifyQueued claims, verifies and saves the queued mention.
+func (m *Mentions) verifyQueued(ctx context.Context, c *http.Client, queued *Mention) {
+	mention, err := m.claim(ctx, queued.Key())
+	if err != nil {
+		if err != errNotClaimable {
+			m.log.Warningf("Failed to claim mention: %s", err)
+		}
+		return
+	}
+	m.log.Infof("Verifying queued webmention from %q", mention.Source)
+	state := mention.State
+	m.verify(ctx, mention, c)
+	if ctx.Err() != nil && mention.RejectMessage != "" {
+		// The failure was probably caused by running out of time, so leave
+		// the mention for the next run, which can claim it once the lease
+		// expires.
+		return
+	}
+	// Save even if ctx is done, so the result isn't lost.
+	if err := m.release(context.Background(), mention, state); err != nil {
+		m.log.Warningf("Failed to save validated message: %s", err)
+	}
+}
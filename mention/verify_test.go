@@ -0,0 +1,129 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyQueuedMentionsPerHostLimit(t *testing.T) {
+	var mutex sync.Mutex
+	current := 0
+	max := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		current++
+		if current > max {
+			max = current
+		}
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		current--
+		mutex.Unlock()
+		fmt.Fprint(w, `<a href="https://bitworking.org/bar">Bar</a>`)
+	}))
+	defer ts.Close()
+
+	m := InitForTesting(t)
+	m.Workers = 8
+	m.PerHostLimit = 2
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, m.Put(ctx, New(fmt.Sprintf("%s/%d", ts.URL, i), "https://bitworking.org/bar")))
+	}
+	m.VerifyQueuedMentions(ctx, ts.Client())
+
+	assert.Len(t, m.GetQueued(ctx), 0)
+	assert.Len(t, m.GetGood(ctx, "https://bitworking.org/bar"), 10)
+	assert.True(t, max <= 2, "max concurrent requests was %d", max)
+	assert.True(t, max >= 1)
+}
+
+func TestVerifyQueuedMentionsBusyHost(t *testing.T) {
+	// Both servers are on 127.0.0.1, so one is reached as localhost to give
+	// it a different host.
+	var mutex sync.Mutex
+	fast := 0
+	fastDone := make(chan struct{})
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		fast++
+		if fast == 3 {
+			close(fastDone)
+		}
+		mutex.Unlock()
+		fmt.Fprint(w, `<a href="https://bitworking.org/bar">Bar</a>`)
+	}))
+	defer fastServer.Close()
+	// The first request to the slow host waits for all the fast host's
+	// mentions to be verified.
+	slow := 0
+	fastFirst := false
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		slow++
+		first := slow == 1
+		mutex.Unlock()
+		if first {
+			select {
+			case <-fastDone:
+				mutex.Lock()
+				fastFirst = true
+				mutex.Unlock()
+			case <-time.After(time.Second):
+			}
+		}
+		fmt.Fprint(w, `<a href="https://bitworking.org/bar">Bar</a>`)
+	}))
+	defer slowServer.Close()
+
+	m := InitForTesting(t)
+	m.Workers = 3
+	m.PerHostLimit = 1
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, m.Put(ctx, New(fmt.Sprintf("%s/slow/%d", slowServer.URL, i), "https://bitworking.org/bar")))
+	}
+	fastURL := strings.Replace(fastServer.URL, "127.0.0.1", "localhost", 1)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, m.Put(ctx, New(fmt.Sprintf("%s/fast/%d", fastURL, i), "https://bitworking.org/bar")))
+	}
+	m.VerifyQueuedMentions(ctx, fastServer.Client())
+
+	assert.Len(t, m.GetQueued(ctx), 0)
+	assert.True(t, fastFirst, "the busy host held up the others")
+}
+
+func TestVerifyQueuedMentionsStopsBeforeDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<a href="https://bitworking.org/bar">Bar</a>`)
+	}))
+	defer ts.Close()
+
+	m := InitForTesting(t)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, m.Put(ctx, New(fmt.Sprintf("%s/%d", ts.URL, i), "https://bitworking.org/bar")))
+	}
+
+	// A single verification could take up to a minute, but there's less
+	// time than that left, so nothing is started.
+	c := ts.Client()
+	c.Timeout = time.Minute
+	deadlineCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	m.VerifyQueuedMentions(deadlineCtx, c)
+	assert.Len(t, m.GetQueued(ctx), 3)
+
+	c.Timeout = 0
+	m.VerifyQueuedMentions(ctx, c)
+	assert.Len(t, m.GetQueued(ctx), 0)
+}
@@ -0,0 +1,168 @@
+// safehttp is a package for fetching URLs that come from untrusted callers,
+// such as the source of a Webmention, without letting them reach hosts that
+// are only meant to be reachable from inside, like localhost, the private
+// network, or the cloud metadata server.
+package safehttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DEFAULT_MAX_REDIRECTS is the number of redirects followed before giving up.
+const DEFAULT_MAX_REDIRECTS = 5
+
+// forbiddenNets are the ranges that untrusted URLs may not be fetched from.
+var forbiddenNets = []*net.IPNet{}
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // "This" network.
+		"10.0.0.0/8",     // Private.
+		"100.64.0.0/10",  // Carrier-grade NAT.
+		"127.0.0.0/8",    // Loopback.
+		"169.254.0.0/16", // Link-local, which includes the metadata server.
+		"172.16.0.0/12",  // Private.
+		"192.0.0.0/24",   // IETF protocol assignments.
+		"192.168.0.0/16", // Private.
+		"198.18.0.0/15",  // Benchmarking.
+		"224.0.0.0/4",    // Multicast.
+		"240.0.0.0/4",    // Reserved, and broadcast.
+		"::/128",         // Unspecified.
+		"::1/128",        // Loopback.
+		"64:ff9b::/96",   // IPv4/IPv6 translation.
+		"fc00::/7",       // Unique local.
+		"fe80::/10",      // Link-local.
+		"ff00::/8",       // Multicast.
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		forbiddenNets = append(forbiddenNets, n)
+	}
+}
+
+// IsForbidden returns true if ip may not be fetched from.
+func IsForbidden(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range forbiddenNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddress returns an error if address, an "ip:port" that is about to be
+// dialed, is forbidden.
+func checkAddress(network, address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("Invalid address %q: %s", address, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("Invalid address %q.", address)
+	}
+	if IsForbidden(ip) {
+		return fmt.Errorf("Address is not allowed: %s", ip)
+	}
+	return nil
+}
+
+// schemeTransport refuses requests for any scheme other than http and https,
+// which covers both the original request and every redirect.
+type schemeTransport struct {
+	http.RoundTripper
+}
+
+func (t schemeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("Scheme is not allowed: %q", req.URL.Scheme)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// NewClient returns an http.Client that only fetches http and https URLs,
+// follows at most DEFAULT_MAX_REDIRECTS redirects, and refuses to connect to
+// loopback, private, link-local and other internal addresses.
+//
+// The address is checked after DNS resolution, right before connecting, so
+// it applies to every redirect and can't be sidestepped by a hostname that
+// resolves to an internal address.
+func NewClient(timeout time.Duration) *http.Client {
+	return newClient(timeout, DEFAULT_MAX_REDIRECTS, checkAddress)
+}
+
+// NewClientForHosts returns an http.Client like NewClient, except that it
+// connects to hosts at any address. It's for fetching pages on our own site,
+// which may well be on a private network, or on localhost while testing.
+// Redirects to any other host are still checked.
+func NewClientForHosts(timeout time.Duration, hosts []string) *http.Client {
+	c := newClient(timeout, DEFAULT_MAX_REDIRECTS, checkAddress)
+	trusted := map[string]bool{}
+	for _, h := range hosts {
+		trusted[strings.ToLower(h)] = true
+	}
+	c.Transport = schemeTransport{hostTransport{
+		trusted:   trusted,
+		direct:    newTransport(timeout, func(network, address string) error { return nil }),
+		untrusted: c.Transport,
+	}}
+	return c
+}
+
+// hostTransport sends requests for trusted hosts through direct, and all
+// others through untrusted.
+type hostTransport struct {
+	trusted   map[string]bool
+	direct    http.RoundTripper
+	untrusted http.RoundTripper
+}
+
+func (t hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.trusted[strings.ToLower(req.URL.Hostname())] {
+		return t.direct.RoundTrip(req)
+	}
+	return t.untrusted.RoundTrip(req)
+}
+
+func newTransport(timeout time.Duration, check func(network, address string) error) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			return check(network, address)
+		},
+	}
+	return &http.Transport{
+		// No Proxy, since the address of a proxy is all that the dialer would
+		// get to check.
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+func newClient(timeout time.Duration, maxRedirects int, check func(network, address string) error) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: schemeTransport{newTransport(timeout, check)},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("Stopped after %d redirects.", maxRedirects)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,155 @@
+package safehttp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// allowOnly returns a check that lets through address, which is how the test
+// servers, all of which listen on loopback, are made reachable one at a time.
+func allowOnly(address string) func(network, address string) error {
+	return func(network, a string) error {
+		if a == address {
+			return nil
+		}
+		return checkAddress(network, a)
+	}
+}
+
+func TestIsForbidden(t *testing.T) {
+	testCases := []struct {
+		ip        string
+		forbidden bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.forbidden, IsForbidden(net.ParseIP(tc.ip)), tc.ip)
+	}
+}
+
+func TestClientRefusesLoopback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secret")
+	}))
+	defer ts.Close()
+
+	_, err := NewClient(time.Second).Get(ts.URL)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Address is not allowed")
+
+	// Also by name.
+	_, err = NewClient(time.Second).Get(strings.Replace(ts.URL, "127.0.0.1", "localhost", 1))
+	assert.Error(t, err)
+}
+
+func TestClientAllowed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer ts.Close()
+
+	c := newClient(time.Second, DEFAULT_MAX_REDIRECTS, allowOnly(ts.Listener.Addr().String()))
+	resp, err := c.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestClientForHosts(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secret")
+	}))
+	defer internal.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, strings.Replace(internal.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+	defer ts.Close()
+
+	c := NewClientForHosts(time.Second, []string{"127.0.0.1"})
+	resp, err := c.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// Other hosts are still checked, including redirects to them.
+	_, err = c.Get(strings.Replace(ts.URL, "127.0.0.1", "localhost", 1))
+	assert.Error(t, err)
+	_, err = c.Get(ts.URL + "/redirect")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Address is not allowed")
+}
+
+func TestClientRefusesRedirectToInternal(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secret")
+	}))
+	defer internal.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusFound)
+	}))
+	defer ts.Close()
+
+	c := newClient(time.Second, DEFAULT_MAX_REDIRECTS, allowOnly(ts.Listener.Addr().String()))
+	_, err := c.Get(ts.URL)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Address is not allowed")
+}
+
+func TestClientRefusesOtherSchemes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	c := newClient(time.Second, DEFAULT_MAX_REDIRECTS, allowOnly(ts.Listener.Addr().String()))
+	_, err := c.Get(ts.URL)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Scheme is not allowed")
+
+	_, err = c.Get("ftp://example.org/")
+	assert.Error(t, err)
+}
+
+func TestClientLimitsRedirects(t *testing.T) {
+	hops := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops++
+		http.Redirect(w, r, "/again", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	c := newClient(time.Second, 3, allowOnly(ts.Listener.Addr().String()))
+	_, err := c.Get(ts.URL)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Stopped after 3 redirects")
+	assert.Equal(t, 4, hops)
+}
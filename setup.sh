@@ -4,3 +4,5 @@ PROJECT=heroic-muse-88515
 
 gcloud --project=${PROJECT} pubsub topics create webmention-validate
 gcloud --project=${PROJECT} beta scheduler jobs create pubsub webmention-validate --schedule="* * * * *" --topic=webmention-validate --message-body="v"
+gcloud --project=${PROJECT} pubsub topics create webmention-recrawl
+gcloud --project=${PROJECT} beta scheduler jobs create pubsub webmention-recrawl --schedule="0 * * * *" --topic=webmention-recrawl --message-body="r"
@@ -0,0 +1,391 @@
+// sqlite is a package for storing mentions in SQLite, for deployments that
+// don't run on Google Cloud.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/jcgregorio/webmention-func/mention"
+)
+
+// migrations are applied in order, and the index of the last one applied is
+// recorded in the database's user_version. Only ever append to this list.
+//
+// Mentions keep the columns that get queried on, i.e. the ones Datastore
+// would index, and the whole Mention is stored as JSON in 'data'.
+var migrations = []string{
+	`CREATE TABLE mentions (
+		key    TEXT PRIMARY KEY,
+		source TEXT NOT NULL,
+		target TEXT NOT NULL,
+		state  TEXT NOT NULL,
+		ts     INTEGER NOT NULL,
+		data   TEXT NOT NULL
+	);
+	CREATE INDEX mentions_target_state ON mentions (target, state);
+	CREATE INDEX mentions_state ON mentions (state);
+	CREATE INDEX mentions_ts ON mentions (ts DESC, key DESC);
+
+	CREATE TABLE sent (
+		source TEXT PRIMARY KEY,
+		ts     INTEGER NOT NULL
+	);
+
+	CREATE TABLE thumbnails (
+		id  TEXT PRIMARY KEY,
+		png BLOB NOT NULL
+	);`,
+
+	`ALTER TABLE mentions ADD COLUMN next_attempt INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX mentions_state_next_attempt ON mentions (state, next_attempt);`,
+
+	// last_verified is filled in from 'data' by backfillLastVerified, and
+	// mentions that were never verified get 0, so they are the first to be
+	// crawled again.
+	`ALTER TABLE mentions ADD COLUMN last_verified INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX mentions_state_last_verified ON mentions (state, last_verified, key);`,
+
+	`ALTER TABLE mentions ADD COLUMN type TEXT NOT NULL DEFAULT '';
+	CREATE INDEX mentions_target_state_type ON mentions (target, state, type);`,
+
+	`CREATE TABLE author_profiles (
+		url     TEXT PRIMARY KEY,
+		expires INTEGER NOT NULL,
+		data    TEXT NOT NULL
+	);`,
+}
+
+// backfills fill in the columns added by the migration with the same index
+// from 'data', since this build of SQLite can't read JSON. Each runs in the
+// same transaction as its migration.
+var backfills = map[int]func(tx *sql.Tx) error{
+	2: backfillLastVerified,
+}
+
+// backfillLastVerified copies LastVerified from 'data' into last_verified,
+// the same as the migration does for PostgreSQL.
+func backfillLastVerified(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT key, data FROM mentions")
+	if err != nil {
+		return err
+	}
+	lastVerified := map[string]int64{}
+	for rows.Next() {
+		var key, data string
+		if err := rows.Scan(&key, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		var m mention.Mention
+		if err := json.Unmarshal([]byte(data), &m); err != nil {
+			rows.Close()
+			return fmt.Errorf("Failed to decode mention %q: %s", key, err)
+		}
+		if !m.LastVerified.IsZero() {
+			lastVerified[key] = toUnix(m.LastVerified)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	for key, t := range lastVerified {
+		if _, err := tx.Exec("UPDATE mentions SET last_verified = ? WHERE key = ?", t, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Store is a mention.Store backed by SQLite.
+type Store struct {
+	db *sql.DB
+}
+
+// New opens the SQLite database at filename, creating it if needed, and
+// brings its schema up to date.
+//
+// Use ":memory:" for a database that only lives as long as the Store.
+func New(filename string) (*Store, error) {
+	dsn := ":memory:"
+	if filename != ":memory:" {
+		dsn = fmt.Sprintf("file:%s?_txlock=immediate&_busy_timeout=5000", filename)
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database: %s", err)
+	}
+	// SQLite only allows a single writer, and each connection to ":memory:"
+	// gets its own database, so keep to one connection.
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{
+		db: db,
+	}, nil
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("Failed to read schema version: %s", err)
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("Failed to start migration %d: %s", i+1, err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to apply migration %d: %s", i+1, err)
+		}
+		if backfill, ok := backfills[i]; ok {
+			if err := backfill(tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("Failed to backfill migration %d: %s", i+1, err)
+			}
+		}
+		// PRAGMA doesn't accept bound parameters.
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to record migration %d: %s", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("Failed to commit migration %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+// toUnix converts t to nanoseconds since the epoch, mapping the zero time to 0.
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnix(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+// queryer is the part of sql.DB and sql.Tx that we need to read a mention.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getMention(ctx context.Context, q queryer, key string) (*mention.Mention, error) {
+	var data string
+	if err := q.QueryRowContext(ctx, "SELECT data FROM mentions WHERE key = ?", key).Scan(&data); err == sql.ErrNoRows {
+		return nil, mention.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read mention: %s", err)
+	}
+	var ret mention.Mention
+	if err := json.Unmarshal([]byte(data), &ret); err != nil {
+		return nil, fmt.Errorf("Failed to decode mention: %s", err)
+	}
+	return &ret, nil
+}
+
+// execer is the part of sql.DB and sql.Tx that we need to write a mention.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func putMention(ctx context.Context, e execer, key string, m *mention.Mention) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("Failed to encode mention: %s", err)
+	}
+	_, err = e.ExecContext(ctx, `INSERT OR REPLACE INTO mentions (key, source, target, state, type, ts, next_attempt, last_verified, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, key, m.Source, m.Target, m.State, m.Type, toUnix(m.TS), toUnix(m.NextAttempt), toUnix(m.LastVerified), string(b))
+	if err != nil {
+		return fmt.Errorf("Failed writing %#v: %s", *m, err)
+	}
+	return nil
+}
+
+func (s *Store) GetMention(ctx context.Context, key string) (*mention.Mention, error) {
+	return getMention(ctx, s.db, key)
+}
+
+func (s *Store) PutMention(ctx context.Context, key string, m *mention.Mention) error {
+	return putMention(ctx, s.db, key, m)
+}
+
+func (s *Store) UpdateMention(ctx context.Context, key string, f func(*mention.Mention) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to start transaction: %s", err)
+	}
+	m, err := getMention(ctx, tx, key)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := f(m); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := putMention(ctx, tx, key, m); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to commit: %s", err)
+	}
+	return nil
+}
+
+func (s *Store) QueryMentions(ctx context.Context, q *mention.Query) ([]*mention.MentionWithKey, error) {
+	ret := []*mention.MentionWithKey{}
+	where := []string{}
+	args := []interface{}{}
+	if q.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, q.Target)
+	}
+	if q.State != "" {
+		where = append(where, "state = ?")
+		args = append(args, q.State)
+	}
+	if q.Type != "" {
+		where = append(where, "type = ?")
+		args = append(args, q.Type)
+	}
+	if !q.DueBefore.IsZero() {
+		// An unset NextAttempt is stored as 0, see toUnix.
+		where = append(where, "next_attempt > 0 AND next_attempt <= ?")
+		args = append(args, toUnix(q.DueBefore))
+	}
+	if !q.VerifiedBefore.IsZero() {
+		where = append(where, "last_verified < ?")
+		args = append(args, toUnix(q.VerifiedBefore))
+	}
+	if q.StartAfter != "" {
+		where = append(where, "(ts, key) < (SELECT ts, key FROM mentions WHERE key = ?)")
+		args = append(args, q.StartAfter)
+	}
+	query := "SELECT key, data FROM mentions"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if q.NewestFirst {
+		query += " ORDER BY ts DESC, key DESC"
+	} else if !q.VerifiedBefore.IsZero() {
+		query += " ORDER BY last_verified, key"
+	} else {
+		query += " ORDER BY key"
+	}
+	if q.Limit > 0 || q.Offset > 0 {
+		// SQLite requires a LIMIT to use OFFSET, and -1 means no limit.
+		limit := -1
+		if q.Limit > 0 {
+			limit = q.Limit
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, q.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return ret, fmt.Errorf("Failed to query mentions: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, data string
+		if err := rows.Scan(&key, &data); err != nil {
+			return ret, fmt.Errorf("Failed while reading: %s", err)
+		}
+		m := &mention.MentionWithKey{
+			Key: key,
+		}
+		if err := json.Unmarshal([]byte(data), &m.Mention); err != nil {
+			return ret, fmt.Errorf("Failed to decode mention: %s", err)
+		}
+		ret = append(ret, m)
+	}
+	if err := rows.Err(); err != nil {
+		return ret, fmt.Errorf("Failed while reading: %s", err)
+	}
+	return ret, nil
+}
+
+func (s *Store) GetSent(ctx context.Context, source string) (time.Time, error) {
+	var ts int64
+	if err := s.db.QueryRowContext(ctx, "SELECT ts FROM sent WHERE source = ?", source).Scan(&ts); err == sql.ErrNoRows {
+		return time.Time{}, mention.ErrNotFound
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to read sent record: %s", err)
+	}
+	return fromUnix(ts), nil
+}
+
+func (s *Store) PutSent(ctx context.Context, source string, ts time.Time) error {
+	if _, err := s.db.ExecContext(ctx, "INSERT OR REPLACE INTO sent (source, ts) VALUES (?, ?)", source, toUnix(ts)); err != nil {
+		return fmt.Errorf("Failed to write sent record: %s", err)
+	}
+	return nil
+}
+
+func (s *Store) GetThumbnail(ctx context.Context, id string) ([]byte, error) {
+	var b []byte
+	if err := s.db.QueryRowContext(ctx, "SELECT png FROM thumbnails WHERE id = ?", id).Scan(&b); err == sql.ErrNoRows {
+		return nil, mention.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to find image: %s", err)
+	}
+	return b, nil
+}
+
+func (s *Store) PutThumbnail(ctx context.Context, id string, png []byte) error {
+	if _, err := s.db.ExecContext(ctx, "INSERT OR REPLACE INTO thumbnails (id, png) VALUES (?, ?)", id, png); err != nil {
+		return fmt.Errorf("Failed to write: %s", err)
+	}
+	return nil
+}
+
+func (s *Store) GetAuthorProfile(ctx context.Context, u string) (*mention.AuthorProfile, error) {
+	var data string
+	if err := s.db.QueryRowContext(ctx, "SELECT data FROM author_profiles WHERE url = ?", u).Scan(&data); err == sql.ErrNoRows {
+		return nil, mention.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read author profile: %s", err)
+	}
+	var ret mention.AuthorProfile
+	if err := json.Unmarshal([]byte(data), &ret); err != nil {
+		return nil, fmt.Errorf("Failed to decode author profile: %s", err)
+	}
+	return &ret, nil
+}
+
+func (s *Store) PutAuthorProfile(ctx context.Context, u string, profile *mention.AuthorProfile) error {
+	b, err := json.Marshal(profile)
+	if err != nil {
+		return fmt.Errorf("Failed to encode author profile: %s", err)
+	}
+	if _, err := s.db.ExecContext(ctx, "INSERT OR REPLACE INTO author_profiles (url, expires, data) VALUES (?, ?, ?)", u, toUnix(profile.Expires), string(b)); err != nil {
+		return fmt.Errorf("Failed to write author profile: %s", err)
+	}
+	return nil
+}
+
+// Assert that Store implements mention.Store.
+var _ mention.Store = (*Store)(nil)
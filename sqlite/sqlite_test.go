@@ -0,0 +1,123 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/webmention-func/mention"
+	"github.com/jcgregorio/webmention-func/mention/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	s, err := New(":memory:")
+	assert.NoError(t, err)
+	defer s.Close()
+	storetest.TestStore(t, s)
+}
+
+func TestReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "webmention.db")
+
+	s, err := New(filename)
+	assert.NoError(t, err)
+	err = s.PutMention(context.Background(), "key", &mention.Mention{
+		Source: "https://example.com/",
+		Target: "https://bitworking.org/",
+		State:  mention.GOOD_STATE,
+		TS:     time.Now(),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	// Migrations that were already applied must not be applied again.
+	s, err = New(filename)
+	assert.NoError(t, err)
+	defer s.Close()
+	m, err := s.GetMention(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/", m.Source)
+}
+
+func TestBackfillLastVerified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "webmention.db")
+
+	// A database from before last_verified was a column.
+	db, err := sql.Open("sqlite3", filename)
+	assert.NoError(t, err)
+	for _, migration := range migrations[:2] {
+		_, err := db.Exec(migration)
+		assert.NoError(t, err)
+	}
+	_, err = db.Exec("PRAGMA user_version = 2")
+	assert.NoError(t, err)
+	now := time.Now()
+	for key, lastVerified := range map[string]time.Time{
+		"old":   now.Add(-2 * time.Hour),
+		"newer": now.Add(-time.Hour),
+		"never": time.Time{},
+	} {
+		b, err := json.Marshal(&mention.Mention{
+			State:        mention.GOOD_STATE,
+			LastVerified: lastVerified,
+		})
+		assert.NoError(t, err)
+		_, err = db.Exec("INSERT INTO mentions (key, source, target, state, ts, data) VALUES (?, '', '', ?, 0, ?)", key, mention.GOOD_STATE, string(b))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, db.Close())
+
+	s, err := New(filename)
+	assert.NoError(t, err)
+	defer s.Close()
+	found, err := s.QueryMentions(context.Background(), &mention.Query{
+		State:          mention.GOOD_STATE,
+		VerifiedBefore: now.Add(-90 * time.Minute),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+	assert.Equal(t, "never", found[0].Key)
+	assert.Equal(t, "old", found[1].Key)
+}
+
+func TestIndexes(t *testing.T) {
+	s, err := New(":memory:")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	plan := func(query string, args ...interface{}) string {
+		rows, err := s.db.Query("EXPLAIN QUERY PLAN "+query, args...)
+		assert.NoError(t, err)
+		defer rows.Close()
+		ret := ""
+		cols, err := rows.Columns()
+		assert.NoError(t, err)
+		for rows.Next() {
+			values := make([]interface{}, len(cols))
+			for i := range values {
+				values[i] = new(interface{})
+			}
+			assert.NoError(t, rows.Scan(values...))
+			ret += fmt.Sprintf("%s\n", *(values[len(values)-1].(*interface{})))
+		}
+		return ret
+	}
+	assert.Contains(t, plan("SELECT data FROM mentions WHERE target = ? AND state = ?", "a", "b"), "mentions_target_state")
+	assert.Contains(t, plan("SELECT data FROM mentions WHERE state = ?", "b"), "mentions_state")
+	assert.Contains(t, plan("SELECT data FROM mentions ORDER BY ts DESC LIMIT 10"), "mentions_ts")
+	assert.Contains(t, plan("SELECT data FROM mentions WHERE target = ? AND state = ? AND type = ?", "a", "b", "like"), "mentions_target_state_type")
+	assert.Contains(t, plan("SELECT data FROM mentions WHERE state = ? AND last_verified < ? ORDER BY last_verified, key LIMIT 10", "good", 1), "mentions_state_last_verified")
+}
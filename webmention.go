@@ -6,21 +6,24 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	units "github.com/docker/go-units"
 
 	"github.com/jcgregorio/logger"
+	"github.com/jcgregorio/slog"
 	"github.com/jcgregorio/webmention-func/admin"
 	"github.com/jcgregorio/webmention-func/config"
 	"github.com/jcgregorio/webmention-func/mention"
+	"github.com/jcgregorio/webmention-func/safehttp"
 )
 
 var (
-	m *mention.Mentions
-
 	log = logger.New()
 
 	triageTemplate = template.Must(template.New("triage").Funcs(template.FuncMap{
@@ -36,7 +39,7 @@ var (
 			}
 			return " • " + units.HumanDuration(time.Now().Sub(t)) + " ago"
 		},
-	}).Parse(fmt.Sprintf(`<!DOCTYPE html>
+	}).Parse(`<!DOCTYPE html>
 <html>
 <head>
     <title></title>
@@ -44,7 +47,7 @@ var (
     <meta http-equiv="X-UA-Compatible" content="IE=egde,chrome=1">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <meta name="google-signin-scope" content="profile email">
-    <meta name="google-signin-client_id" content="%s">
+    <meta name="google-signin-client_id" content="{{.ClientID}}">
     <script src="https://apis.google.com/js/platform.js" async defer></script>
 		<style type="text/css" media="screen">
 		  #webmentions {
@@ -66,21 +69,37 @@ var (
         }
       };
     </script>
+  {{ if gt (len .Sites) 1 }}
+  <nav>
+    {{ $current := .Site }}
+    {{ range .Sites }}
+      {{ if eq . $current }}<b>{{ . }}</b>{{ else }}<a href="?site={{ . }}">{{ . }}</a>{{ end }}
+    {{ end }}
+  </nav>
+  {{ end }}
   <div id=webmentions>
   {{range .Mentions }}
 		<select name="text" data-key="{{ .Key }}">
 			<option value="good" {{if eq .State "good" }}selected{{ end }} >Good</option>
 			<option value="spam" {{if eq .State "spam" }}selected{{ end }} >Spam</option>
 			<option value="untriaged" {{if eq .State "untriaged" }}selected{{ end }} >Untriaged</option>
+			<option value="retry" {{if eq .State "retry" }}selected{{ end }} >Retry</option>
+			<option value="failed" {{if eq .State "failed" }}selected{{ end }} >Failed</option>
+			<option value="deleted" {{if eq .State "deleted" }}selected{{ end }} >Deleted</option>
 		</select>
 		<span>{{ .TS | humanTime }}</span>
 		<div>
 		  <div>Source: <a href="{{ .Source }}">{{ .Source | trunc }}</a></div>
 			<div>Target: <a href="{{ .Target }}">{{ .Target | trunc }}</a></div>
+			{{ if .Type }}<div>Type: {{ .Type }}</div>{{ end }}
+			{{ if .Excerpt }}<div>{{ .Excerpt }}</div>{{ end }}
+			{{ if not .LastVerified.IsZero }}
+			<div>Verified{{ .LastVerified | humanTime }}{{ if .RejectReason }}: {{ if eq .State "good" }}<b>last check failed</b>{{ else }}rejected{{ end }}, {{ .RejectReason }} - {{ .RejectMessage | trunc }}{{ end }}</div>
+			{{ end }}
 		</div>
   {{end}}
   </div>
-	<div><a href="?offset={{.Offset}}">Next</a></div>
+	{{ if .After }}<div><a href="?site={{.Site}}&limit={{.Limit}}&after={{.After}}">Next</a></div>{{ end }}
 	<script type="text/javascript" charset="utf-8">
 	 // TODO - listen on div.webmentions for click/input and then write
 	 // triage action back to server.
@@ -91,6 +110,7 @@ var (
 			   credentials: 'same-origin',
 				 method: 'POST',
 				 body: JSON.stringify({
+					 site: {{.Site}},
 					 key: e.target.dataset.key,
 					 value:  e.target.value,
 				 }),
@@ -102,7 +122,7 @@ var (
 	 });
 	</script>
 </body>
-</html>`, config.CLIENT_ID)))
+</html>`))
 
 	mentionsTemplate = template.Must(template.New("mentions").Funcs(template.FuncMap{
 		"humanTime": func(t time.Time) string {
@@ -123,16 +143,38 @@ var (
 			}
 			return s
 		},
+		// sanitize is applied again when rendering, so that only the allowed
+		// elements ever reach the page, whatever is in the store.
+		"sanitize": func(content, source string) template.HTML {
+			return template.HTML(mention.SanitizeHTML(content, source))
+		},
 	}).Parse(`
-	<section id=webmention>
-	<h3>WebMentions</h3>
-	{{ $host := .Host }}
-	{{ range .Mentions }}
+	{{ define "facepile" }}
+		<div class="wm-facepile">
+		{{ range .Mentions }}
+			<a href="{{ or .OriginalURL .Source }}" rel=nofollow title="{{ .Author }}{{ with .Via }} via {{ . }}{{ end }}">
+				{{ if .Thumbnail }}
+					<img src="{{ $.Host }}/Thumbnail/{{ .Thumbnail }}?site={{ $.Site }}" alt="{{ .Author }}"/>
+				{{ else if .Author }}
+					{{ .Author }}
+				{{ else if .Via }}
+					{{ .Via }}
+				{{ else }}
+					{{ .Source | trunc }}
+				{{ end }}
+				{{ if .Silo }}<span class="wm-silo wm-silo-{{ .Silo }}"></span>{{ end }}
+			</a>
+		{{ end }}
+		</div>
+	{{ end }}
+	{{ define "list" }}
+		{{ range $m := .Mentions }}
+		<div class="wm-{{ .Type }}">
 			<span class="wm-author">
 				{{ if .AuthorURL }}
 					{{ if .Thumbnail }}
 					<a href="{{ .AuthorURL}}" rel=nofollow class="wm-thumbnail">
-						<img src="{{ $host }}/Thumbnail/{{ .Thumbnail }}"/>
+						<img src="{{ $.Host }}/Thumbnail/{{ .Thumbnail }}?site={{ $.Site }}"/>
 					</a>
 					{{ end }}
 					<a href="{{ .AuthorURL}}" rel=nofollow>
@@ -143,145 +185,579 @@ var (
 				{{ end }}
 			</span>
 			<time datetime="{{ .Published | rfc3999 }}">{{ .Published | humanTime }}</time>
-			<a class="wm-content" href="{{ .Source }}" rel=nofollow>
+			<a class="wm-content" href="{{ or .OriginalURL .Source }}" rel=nofollow>
 				{{ if .Title }}
 					{{ .Title | trunc }}
 				{{ else }}
-					{{ .Source | trunc }}
+					{{ or .OriginalURL .Source | trunc }}
 				{{ end }}
 			</a>
+			{{ with .Via }}<span class="wm-via{{ if $m.Silo }} wm-silo wm-silo-{{ $m.Silo }}{{ end }}">via {{ . }}</span>{{ end }}
+			{{ if and (eq .Type "reply") .Content }}
+			<div class="wm-text">{{ sanitize .Content .Source }}</div>
+			{{ else if .Excerpt }}
+			<p class="wm-text">{{ .Excerpt }}</p>
+			{{ end }}
+		</div>
+		{{ end }}
 	{{ end }}
+	<section id=webmention>
+	<h3>WebMentions</h3>
+	{{ with .Of "like" }}{{ if .Mentions }}
+		<h4>{{ len .Mentions }} likes</h4>
+		{{ template "facepile" . }}
+	{{ end }}{{ end }}
+	{{ with .Of "repost" }}{{ if .Mentions }}
+		<h4>{{ len .Mentions }} reposts</h4>
+		{{ template "facepile" . }}
+	{{ end }}{{ end }}
+	{{ template "list" (.Of "reply") }}
+	{{ template "list" (.Of "mention" "bookmark" "rsvp") }}
 	</section>
 `))
 )
 
-func init() {
-	var err error
-	m, err = mention.NewMentions(context.Background(), config.PROJECT, config.DATASTORE_NAMESPACE, log)
+// statusTemplate is the HTML version of the status page for a single mention.
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Webmention Status</title>
+    <meta charset="utf-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+</head>
+<body>
+  <h1>Webmention Status</h1>
+  <dl>
+    <dt>Source</dt><dd><a href="{{ .Source }}" rel=nofollow>{{ .Source }}</a></dd>
+    <dt>Target</dt><dd><a href="{{ .Target }}">{{ .Target }}</a></dd>
+    <dt>Received</dt><dd><time datetime="{{ .Received }}">{{ .Received }}</time></dd>
+    <dt>Status</dt><dd>{{ .Status }}</dd>
+    {{ if .LastVerified }}<dt>Last Verified</dt><dd><time datetime="{{ .LastVerified }}">{{ .LastVerified }}</time></dd>{{ end }}
+    {{ if .Reason }}<dt>Reason</dt><dd>{{ .Reason }}{{ if .ReasonCode }} ({{ .ReasonCode }}){{ end }}</dd>{{ end }}
+  </dl>
+</body>
+</html>`))
+
+// The statuses reported on the status page of a mention.
+const (
+	STATUS_QUEUED   = "queued"
+	STATUS_VERIFIED = "verified"
+	STATUS_REJECTED = "rejected"
+	STATUS_DELETED  = "deleted"
+)
+
+// site is a configured site along with its mentions.
+type site struct {
+	*config.Site
+	mentions *mention.Mentions
+}
+
+// Server implements all the Webmention handlers.
+type Server struct {
+	config *config.Config
+	sites  []*site
+	log    slog.Logger
+}
+
+// NewServer creates a new Server for the sites in c, where mentions holds the
+// Mentions for each site, keyed by site name.
+func NewServer(c *config.Config, mentions map[string]*mention.Mentions, log slog.Logger) (*Server, error) {
+	sites := []*site{}
+	for _, configSite := range c.Sites {
+		m, ok := mentions[configSite.Name]
+		if !ok {
+			return nil, fmt.Errorf("No mentions supplied for site %q.", configSite.Name)
+		}
+		applyLimits(m, c.Limits)
+		if c.RecrawlBudget > 0 {
+			m.RecrawlBudget = c.RecrawlBudget
+		}
+		if c.RecrawlAfterHours > 0 {
+			m.RecrawlAfter = time.Duration(c.RecrawlAfterHours) * time.Hour
+		}
+		m.URLPolicy = mention.URLPolicy{
+			KeepScheme:        c.URLPolicy.KeepScheme,
+			KeepWWW:           c.URLPolicy.KeepWWW,
+			KeepTrailingSlash: c.URLPolicy.KeepTrailingSlash,
+			KeepFragment:      c.URLPolicy.KeepFragment,
+		}
+		switch configSite.TargetCheck {
+		case config.TARGET_CHECK_REQUEST:
+			m.TargetChecker = mention.NewTargetChecker(targetClient(configSite, m.URLPolicy), "", m.URLPolicy)
+		case config.TARGET_CHECK_SITEMAP:
+			m.TargetChecker = mention.NewTargetChecker(targetClient(configSite, m.URLPolicy), configSite.Sitemap, m.URLPolicy)
+		}
+		sites = append(sites, &site{
+			Site:     configSite,
+			mentions: m,
+		})
+	}
+	return &Server{
+		config: c,
+		sites:  sites,
+		log:    log,
+	}, nil
+}
+
+// applyLimits overrides the limits of m with those set in limits.
+func applyLimits(m *mention.Mentions, limits config.Limits) {
+	if limits.MaxSourceBytes > 0 {
+		m.MaxSourceBytes = limits.MaxSourceBytes
+	}
+	if len(limits.SourceContentTypes) > 0 {
+		m.SourceContentTypes = limits.SourceContentTypes
+	}
+	if limits.MaxImageBytes > 0 {
+		m.MaxImageBytes = limits.MaxImageBytes
+	}
+	if limits.MaxImageWidth > 0 {
+		m.MaxImageWidth = limits.MaxImageWidth
+	}
+	if limits.MaxImageHeight > 0 {
+		m.MaxImageHeight = limits.MaxImageHeight
+	}
+	if limits.MaxImagePixels > 0 {
+		m.MaxImagePixels = limits.MaxImagePixels
+	}
+}
+
+// siteByName returns the site with the given name, or the first site if name
+// is empty. Returns nil if there's no such site.
+func (s *Server) siteByName(name string) *site {
+	if name == "" {
+		return s.sites[0]
+	}
+	for _, site := range s.sites {
+		if site.Name == name {
+			return site
+		}
+	}
+	return nil
+}
+
+// siteByURL returns the site that u is on, or nil if u isn't on any site.
+func (s *Server) siteByURL(u string) *site {
+	parsed, err := url.Parse(u)
 	if err != nil {
-		log.Fatal(err)
+		return nil
 	}
+	for _, site := range s.sites {
+		policy := site.mentions.URLPolicy
+		for _, host := range site.Hosts {
+			if policy.NormalizeHost(host) == policy.NormalizeHost(parsed.Hostname()) {
+				return site
+			}
+		}
+	}
+	return nil
+}
+
+// AddHandlers adds all the handlers to mux, at the same paths they are
+// deployed to as Cloud Functions.
+func (s *Server) AddHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/Triage", s.Triage)
+	mux.HandleFunc("/UpdateMention", s.UpdateMention)
+	mux.HandleFunc("/Mentions", s.Mentions)
+	mux.HandleFunc("/IncomingWebMention", s.IncomingWebMention)
+	mux.HandleFunc("/Thumbnail/", s.Thumbnail)
+	mux.HandleFunc("/Status/", s.Status)
 }
 
 type triageContext struct {
+	ClientID string
 	IsAdmin  bool
+	Site     string
+	Sites    []string
 	Mentions []*mention.MentionWithKey
-	Offset   int64
+	Limit    int
+
+	// After is the key of the last mention shown, or empty if this is the
+	// last page.
+	After string
 }
 
-// Triage displays the triage page for Webmentions.
-func Triage(w http.ResponseWriter, r *http.Request) {
+// Triage displays the triage page for the Webmentions of one site, given by
+// the 'site' query parameter, or the first site if not given.
+func (s *Server) Triage(w http.ResponseWriter, r *http.Request) {
+	site := s.siteByName(r.FormValue("site"))
+	if site == nil {
+		http.Error(w, "Unknown site", 404)
+		return
+	}
 	w.Header().Set("Content-Type", "text/html")
-	context := &triageContext{}
-	isAdmin := admin.IsAdmin(r, log)
+	context := &triageContext{
+		ClientID: s.config.ClientID,
+	}
+	isAdmin := admin.IsAdmin(r, s.config.ClientID, site.Site, s.log)
 	if isAdmin {
 		limitText := r.FormValue("limit")
 		if limitText == "" {
 			limitText = "20"
 		}
-		offsetText := r.FormValue("offset")
-		if offsetText == "" {
-			offsetText = "0"
-		}
 		limit, err := strconv.ParseInt(limitText, 10, 32)
 		if err != nil {
-			log.Infof("Failed to parse limit: %s", err)
+			s.log.Infof("Failed to parse limit: %s", err)
 			return
 		}
-		offset, err := strconv.ParseInt(offsetText, 10, 32)
-		if err != nil {
-			log.Infof("Failed to parse offset: %s", err)
-			return
+		mentions := site.mentions.GetTriage(r.Context(), int(limit), r.FormValue("after"))
+		// A short page is the last one.
+		after := ""
+		if len(mentions) > 0 && len(mentions) == int(limit) {
+			after = mentions[len(mentions)-1].Key
+		}
+		sites := []string{}
+		for _, site := range s.sites {
+			sites = append(sites, site.Name)
 		}
 		context = &triageContext{
+			ClientID: s.config.ClientID,
 			IsAdmin:  isAdmin,
-			Mentions: m.GetTriage(r.Context(), int(limit), int(offset)),
-			Offset:   offset + limit,
+			Site:     site.Name,
+			Sites:    sites,
+			Mentions: mentions,
+			Limit:    int(limit),
+			After:    after,
 		}
 	}
 	if err := triageTemplate.Execute(w, context); err != nil {
-		log.Errorf("Failed to render triage template: %s", err)
+		s.log.Errorf("Failed to render triage template: %s", err)
 	}
 }
 
 type updateMention struct {
+	Site  string `json:"site"`
 	Key   string `json:"key"`
 	Value string `json:"value"`
 }
 
 // UpdateMention updates the triage state of a webmention.
 // Called from the Triage page.
-func UpdateMention(w http.ResponseWriter, r *http.Request) {
-	isAdmin := admin.IsAdmin(r, log)
-	if !isAdmin {
-		http.Error(w, "Unauthorized", 401)
-	}
+func (s *Server) UpdateMention(w http.ResponseWriter, r *http.Request) {
 	var u updateMention
 	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		log.Infof("Failed to decode update: %s", err)
+		s.log.Infof("Failed to decode update: %s", err)
 		http.Error(w, "Bad JSON", 400)
+		return
+	}
+	site := s.siteByName(u.Site)
+	if site == nil {
+		http.Error(w, "Unknown site", 404)
+		return
+	}
+	isAdmin := admin.IsAdmin(r, s.config.ClientID, site.Site, s.log)
+	if !isAdmin {
+		http.Error(w, "Unauthorized", 401)
+		return
 	}
-	if err := m.UpdateState(r.Context(), u.Key, u.Value); err != nil {
-		log.Infof("Failed to write update: %s", err)
+	if err := site.mentions.UpdateState(r.Context(), u.Key, u.Value); err == mention.ErrUnknownState {
+		http.Error(w, "Unknown state", 400)
+	} else if err != nil {
+		s.log.Infof("Failed to write update: %s", err)
 		http.Error(w, "Failed to write", 400)
 	}
 }
 
 type MentionsContext struct {
 	Host     string
+	Site     string
 	Mentions []*mention.Mention
 }
 
+// Of returns the mentions of the given types, so that the template can show
+// likes and reposts as facepiles, and replies as comments. Mentions that were
+// verified before they had a type count as mention.TYPE_MENTION.
+func (c MentionsContext) Of(types ...string) MentionsContext {
+	ret := MentionsContext{
+		Host:     c.Host,
+		Site:     c.Site,
+		Mentions: []*mention.Mention{},
+	}
+	for _, m := range c.Mentions {
+		t := m.Type
+		if t == "" {
+			t = mention.TYPE_MENTION
+		}
+		for _, want := range types {
+			if t == want {
+				ret.Mentions = append(ret.Mentions, m)
+				break
+			}
+		}
+	}
+	return ret
+}
+
 // Mentions returns HTML describing all the good Webmentions for the given URL.
-func Mentions(w http.ResponseWriter, r *http.Request) {
+func (s *Server) Mentions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
-	mentions := m.GetGood(r.Context(), r.Referer())
+	site := s.siteByURL(r.Referer())
+	if site == nil {
+		return
+	}
+	mentions := site.mentions.GetGood(r.Context(), r.Referer())
 	if len(mentions) == 0 {
 		return
 	}
 	context := MentionsContext{
-		Host:     config.HOST,
+		Host:     s.config.Host,
+		Site:     site.Name,
 		Mentions: mentions,
 	}
 	if err := mentionsTemplate.Execute(w, context); err != nil {
-		log.Errorf("Failed to expand template: %s", err)
+		s.log.Errorf("Failed to expand template: %s", err)
 	}
 }
 
-// IncomingWebMention handles incoming Webmentions.
-func IncomingWebMention(w http.ResponseWriter, r *http.Request) {
+// targetClient returns the client that the site's TargetChecker uses. Targets
+// are always on the site's own hosts, and so is the sitemap usually, so
+// they're trusted, since the site may be on a private network, or on
+// localhost while testing, where safehttp.NewClient would refuse to go.
+//
+// The TargetChecker requests targets as normalized by policy, so the
+// normalized forms of the hosts are trusted too.
+func targetClient(site *config.Site, policy mention.URLPolicy) *http.Client {
+	hosts := []string{}
+	for _, host := range site.Hosts {
+		hosts = append(hosts, host, policy.NormalizeHost(host))
+	}
+	if u, err := url.Parse(site.Sitemap); err == nil && u.Hostname() != "" {
+		hosts = append(hosts, u.Hostname())
+	}
+	return safehttp.NewClientForHosts(10*time.Second, hosts)
+}
+
+// IncomingWebMention handles incoming Webmentions, storing each one in the
+// site its target belongs to.
+func (s *Server) IncomingWebMention(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	mention := mention.New(r.FormValue("source"), r.FormValue("target"))
-	if err := mention.FastValidate(); err != nil {
-		log.Infof("Invalid request: %s", err)
+	site := s.siteByURL(mention.Target)
+	if site == nil {
+		s.log.Infof("Invalid request: No site for target %q", mention.Target)
 		http.Error(w, fmt.Sprintf("Invalid request."), 400)
 		return
 	}
-	if err := m.Put(r.Context(), mention); err != nil {
-		log.Infof("Failed to enqueue mention: %s", err)
+	if err := mention.FastValidate(site.Hosts, site.mentions.URLPolicy); err != nil {
+		s.log.Infof("Invalid request: %s", err)
+		http.Error(w, fmt.Sprintf("Invalid request."), 400)
+		return
+	}
+	if site.mentions.TargetChecker != nil {
+		exists, err := site.mentions.TargetChecker.Exists(r.Context(), mention.Target)
+		if err != nil {
+			// Rather than lose the mention, accept it and let triage sort it out.
+			// This is also what happens if the target check is misconfigured,
+			// so it's logged as a warning.
+			s.log.Warningf("Failed to check target %q: %s", mention.Target, err)
+		} else if !exists {
+			s.log.Infof("Invalid request: No such target %q", mention.Target)
+			http.Error(w, "Target does not exist.", 400)
+			return
+		}
+	}
+	if err := site.mentions.Receive(r.Context(), mention, r.FormValue("target")); err != nil {
+		s.log.Infof("Failed to enqueue mention: %s", err)
 		http.Error(w, fmt.Sprintf("Failed to enqueue mention."), 400)
 		return
 	}
-	w.WriteHeader(http.StatusAccepted)
+	// Point the sender at the status page, as described in
+	// https://www.w3.org/TR/webmention/#receiving-webmentions.
+	w.Header().Set("Location", s.statusURL(site, mention))
+	w.WriteHeader(http.StatusCreated)
 }
 
-func Thumbnail(w http.ResponseWriter, r *http.Request) {
+// statusURL returns the URL of the status page for mention.
+func (s *Server) statusURL(site *site, mention *mention.Mention) string {
+	return fmt.Sprintf("%s/Status/%s?site=%s", s.config.Host, mention.Key(), url.QueryEscape(site.Name))
+}
+
+// statusResponse is the status of a single mention, as reported by Status.
+type statusResponse struct {
+	Source       string `json:"source"`
+	Target       string `json:"target"`
+	Received     string `json:"received"`
+	Status       string `json:"status"`
+	LastVerified string `json:"last_verified,omitempty"`
+
+	// Reason is a human readable explanation of why the mention was
+	// rejected, and ReasonCode is one of the mention.REASON_* values.
+	Reason     string         `json:"reason,omitempty"`
+	ReasonCode mention.Reason `json:"reason_code,omitempty"`
+}
+
+func newStatusResponse(m *mention.Mention) *statusResponse {
+	ret := &statusResponse{
+		Source:   m.Source,
+		Target:   m.Target,
+		Received: m.TS.UTC().Format(time.RFC3339),
+	}
+	if !m.LastVerified.IsZero() {
+		ret.LastVerified = m.LastVerified.UTC().Format(time.RFC3339)
+	}
+	switch m.State {
+	case mention.GOOD_STATE:
+		ret.Status = STATUS_VERIFIED
+	case mention.RETRY_STATE:
+		ret.Status = STATUS_QUEUED
+		ret.Reason = fmt.Sprintf("%s Will retry after %s.", m.RejectMessage, m.NextAttempt.UTC().Format(time.RFC3339))
+		ret.ReasonCode = m.RejectReason
+	case mention.SPAM_STATE, mention.FAILED_STATE:
+		ret.Status = STATUS_REJECTED
+		ret.Reason = m.RejectMessage
+		ret.ReasonCode = m.RejectReason
+		if ret.Reason == "" {
+			ret.Reason = "Rejected during triage."
+		}
+	case mention.DELETED_STATE:
+		ret.Status = STATUS_DELETED
+		ret.Reason = m.RejectMessage
+		ret.ReasonCode = m.RejectReason
+	default:
+		ret.Status = STATUS_QUEUED
+	}
+	return ret
+}
+
+// Status reports the status of a single mention, given by its key as the last
+// part of the path, for the site given by the 'site' query parameter.
+//
+// The response is JSON if the request accepts application/json, otherwise
+// HTML.
+func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
+	site := s.siteByName(r.FormValue("site"))
+	if site == nil {
+		http.Error(w, "Mention not found", 404)
+		return
+	}
+	m, err := site.mentions.Get(r.Context(), path.Base(r.URL.Path))
+	if err == mention.ErrNotFound {
+		http.Error(w, "Mention not found", 404)
+		return
+	} else if err != nil {
+		s.log.Warningf("Failed to get mention: %s", err)
+		http.Error(w, "Failed to get mention", 500)
+		return
+	}
+	status := newStatusResponse(m)
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			s.log.Errorf("Failed to write status: %s", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := statusTemplate.Execute(w, status); err != nil {
+		s.log.Errorf("Failed to expand template: %s", err)
+	}
+}
+
+// Thumbnail serves the thumbnail images of Webmention authors, for the site
+// given by the 'site' query parameter.
+func (s *Server) Thumbnail(w http.ResponseWriter, r *http.Request) {
+	site := s.siteByName(r.FormValue("site"))
+	if site == nil {
+		http.Error(w, "Image not found", 404)
+		return
+	}
 	w.Header().Set("Content-Type", "image/png")
-	b, err := m.GetThumbnail(r.Context(), path.Base(r.URL.Path))
+	b, err := site.mentions.GetThumbnail(r.Context(), path.Base(r.URL.Path))
 	if err != nil {
 		http.Error(w, "Image not found", 404)
-		log.Warningf("Failed to get image: %s", err)
+		s.log.Warningf("Failed to get image: %s", err)
 		return
 	}
 	if _, err = w.Write(b); err != nil {
-		log.Errorf("Failed to write image: %s", err)
+		s.log.Errorf("Failed to write image: %s", err)
 		return
 	}
 }
 
+// VerifyQueuedMentions verifies untriaged webmentions for every site, until
+// ctx is done.
+//
+// Sources and photos are fetched with a safehttp client, since their URLs
+// come from whoever sent the Webmention.
+func (s *Server) VerifyQueuedMentions(ctx context.Context) {
+	client := safehttp.NewClient(time.Second * 30)
+	for _, site := range s.sites {
+		site.mentions.VerifyQueuedMentions(ctx, client)
+	}
+}
+
+// ScheduleRecrawl schedules good mentions of every site to be verified again
+// by the next VerifyQueuedMentions, see mention.Mentions.ScheduleRecrawl.
+func (s *Server) ScheduleRecrawl(ctx context.Context) {
+	for _, site := range s.sites {
+		site.mentions.ScheduleRecrawl(ctx)
+	}
+}
+
+// The functions below are the entry points when deployed as Cloud Functions.
+// They all share a single Server that uses Cloud Datastore, which is created
+// on first use so that importing this package doesn't require Google Cloud
+// credentials. The config is loaded with config.LoadFromEnv.
+
+var (
+	defaultServer     *Server
+	defaultServerOnce sync.Once
+)
+
+func getDefaultServer() *Server {
+	defaultServerOnce.Do(func() {
+		c, err := config.LoadFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		mentions := map[string]*mention.Mentions{}
+		for _, site := range c.Sites {
+			m, err := mention.NewMentions(context.Background(), c.Project, site.Namespace, log)
+			if err != nil {
+				log.Fatal(err)
+			}
+			mentions[site.Name] = m
+		}
+		defaultServer, err = NewServer(c, mentions, log)
+		if err != nil {
+			log.Fatal(err)
+		}
+	})
+	return defaultServer
+}
+
+// Triage displays the triage page for Webmentions.
+func Triage(w http.ResponseWriter, r *http.Request) {
+	getDefaultServer().Triage(w, r)
+}
+
+// UpdateMention updates the triage state of a webmention.
+// Called from the Triage page.
+func UpdateMention(w http.ResponseWriter, r *http.Request) {
+	getDefaultServer().UpdateMention(w, r)
+}
+
+// Mentions returns HTML describing all the good Webmentions for the given URL.
+func Mentions(w http.ResponseWriter, r *http.Request) {
+	getDefaultServer().Mentions(w, r)
+}
+
+// IncomingWebMention handles incoming Webmentions.
+func IncomingWebMention(w http.ResponseWriter, r *http.Request) {
+	getDefaultServer().IncomingWebMention(w, r)
+}
+
+func Thumbnail(w http.ResponseWriter, r *http.Request) {
+	getDefaultServer().Thumbnail(w, r)
+}
+
+// Status reports the status of a single Webmention.
+func Status(w http.ResponseWriter, r *http.Request) {
+	getDefaultServer().Status(w, r)
+}
+
 type PubSubMessage struct {
 	Data []byte `json:"data"`
 }
@@ -290,9 +766,17 @@ type PubSubMessage struct {
 //
 // Should be called on a timer.
 func VerifyQueuedMentions(ctx context.Context, ps PubSubMessage) error {
-	client := &http.Client{
-		Timeout: time.Second * 30,
-	}
-	m.VerifyQueuedMentions(client)
+	getDefaultServer().VerifyQueuedMentions(ctx)
+	return nil
+}
+
+// RecrawlMentions verifies the good mentions that were verified longest ago
+// again, to find the ones whose source has gone or changed.
+//
+// Should be called on a timer, less often than VerifyQueuedMentions.
+func RecrawlMentions(ctx context.Context, ps PubSubMessage) error {
+	s := getDefaultServer()
+	s.ScheduleRecrawl(ctx)
+	s.VerifyQueuedMentions(ctx)
 	return nil
 }
@@ -0,0 +1,247 @@
+package webmention
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jcgregorio/logger"
+	"github.com/jcgregorio/webmention-func/config"
+	"github.com/jcgregorio/webmention-func/mention"
+	"github.com/stretchr/testify/assert"
+)
+
+// newServerForTesting returns a Server for two sites, "blog" and "photos",
+// whose mentions are kept in memory.
+func newServerForTesting(t *testing.T) (*Server, map[string]*mention.Mentions) {
+	c := &config.Config{
+		ClientID: "abc",
+		Host:     "https://webmention.example.com",
+		Sites: []*config.Site{
+			{
+				Name:      "blog",
+				Hosts:     []string{"bitworking.org"},
+				Admins:    []string{"me@example.com"},
+				Namespace: "blog",
+			},
+			{
+				Name:      "photos",
+				Hosts:     []string{"photos.example.com"},
+				Admins:    []string{"me@example.com"},
+				Namespace: "photos",
+			},
+		},
+	}
+	assert.NoError(t, c.Validate())
+	mentions := map[string]*mention.Mentions{}
+	for _, site := range c.Sites {
+		mentions[site.Name] = mention.NewMentionsWithStore(mention.NewMemoryStore(), logger.New())
+	}
+	s, err := NewServer(c, mentions, logger.New())
+	assert.NoError(t, err)
+	return s, mentions
+}
+
+func postMention(s *Server, source, target string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Set("source", source)
+	form.Set("target", target)
+	r := httptest.NewRequest("POST", "/IncomingWebMention", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.IncomingWebMention(w, r)
+	return w
+}
+
+func TestIncomingWebMentionRoutesToSite(t *testing.T) {
+	s, mentions := newServerForTesting(t)
+
+	w := postMention(s, "https://example.com/a", "https://photos.example.com/1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = postMention(s, "https://example.com/a", "https://bitworking.org/news/1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = postMention(s, "https://example.com/a", "https://example.org/news/1")
+	assert.Equal(t, 400, w.Code)
+
+	assert.Len(t, mentions["photos"].GetAll(context.Background(), "https://photos.example.com/1"), 1)
+	assert.Len(t, mentions["blog"].GetAll(context.Background(), "https://photos.example.com/1"), 0)
+	assert.Len(t, mentions["blog"].GetAll(context.Background(), "https://bitworking.org/news/1"), 1)
+}
+
+func TestMentionsScopedToSite(t *testing.T) {
+	s, mentions := newServerForTesting(t)
+	m := mention.New("https://example.com/a", "https://photos.example.com/1")
+	m.State = mention.GOOD_STATE
+	m.Title = "A photo reply"
+	assert.NoError(t, mentions["photos"].Put(context.Background(), m))
+
+	r := httptest.NewRequest("GET", "/Mentions", nil)
+	r.Header.Set("Referer", "https://photos.example.com/1")
+	w := httptest.NewRecorder()
+	s.Mentions(w, r)
+	assert.Contains(t, w.Body.String(), "A photo reply")
+
+	r = httptest.NewRequest("GET", "/Mentions", nil)
+	r.Header.Set("Referer", "https://bitworking.org/1")
+	w = httptest.NewRecorder()
+	s.Mentions(w, r)
+	assert.Equal(t, "", w.Body.String())
+}
+
+func TestMentionsGroupedByType(t *testing.T) {
+	s, mentions := newServerForTesting(t)
+	for _, m := range []struct {
+		source string
+		typ    string
+		title  string
+	}{
+		{"https://example.com/like", mention.TYPE_LIKE, "A like"},
+		{"https://example.com/repost", mention.TYPE_REPOST, "A repost"},
+		{"https://example.com/reply", mention.TYPE_REPLY, "A reply"},
+		{"https://example.com/mention", "", "A mention"},
+	} {
+		found := mention.New(m.source, "https://photos.example.com/1")
+		found.State = mention.GOOD_STATE
+		found.Type = m.typ
+		found.Title = m.title
+		found.Author = "Joe"
+		found.Content = "<p>Nice <script>alert(1)</script>" + m.title + "</p>"
+		if m.typ == mention.TYPE_REPLY {
+			found.OriginalURL = "https://mastodon.social/@joe/1234"
+		}
+		if m.typ == mention.TYPE_LIKE {
+			found.Silo = mention.SILO_TWITTER
+		}
+		assert.NoError(t, mentions["photos"].Put(context.Background(), found))
+	}
+
+	r := httptest.NewRequest("GET", "/Mentions", nil)
+	r.Header.Set("Referer", "https://photos.example.com/1")
+	w := httptest.NewRecorder()
+	s.Mentions(w, r)
+	body := w.Body.String()
+	assert.Contains(t, body, "1 likes")
+	assert.Contains(t, body, "1 reposts")
+	assert.Contains(t, body, `class="wm-reply"`)
+	assert.Contains(t, body, "A reply")
+	assert.Contains(t, body, "A mention")
+	// Replies show their content, which is sanitized.
+	assert.Contains(t, body, "<p>Nice A reply</p>")
+	assert.NotContains(t, body, "<script>")
+	assert.Contains(t, body, "via Mastodon")
+	assert.Contains(t, body, "wm-silo-twitter")
+	// Likes and reposts are only shown in the facepile.
+	assert.NotContains(t, body, "A like")
+	assert.NotContains(t, body, "A repost")
+}
+
+func TestStatus(t *testing.T) {
+	s, mentions := newServerForTesting(t)
+	w := postMention(s, "https://example.com/a", "https://photos.example.com/1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	location := w.Header().Get("Location")
+	m := mention.New("https://example.com/a", "https://photos.example.com/1")
+	assert.Equal(t, "https://webmention.example.com/Status/"+m.Key()+"?site=photos", location)
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", location, nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		s.Status(w, r)
+		return w
+	}
+
+	w = get("application/json")
+	assert.Equal(t, 200, w.Code)
+	var status statusResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.Equal(t, STATUS_QUEUED, status.Status)
+	assert.Equal(t, "https://example.com/a", status.Source)
+
+	assert.NoError(t, mentions["photos"].UpdateState(context.Background(), m.Key(), mention.SPAM_STATE))
+	w = get("text/html")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), STATUS_REJECTED)
+
+	// Mentions are only found in their own site.
+	r := httptest.NewRequest("GET", "/Status/"+m.Key()+"?site=blog", nil)
+	w = httptest.NewRecorder()
+	s.Status(w, r)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestIncomingWebMentionNormalizesTarget(t *testing.T) {
+	s, mentions := newServerForTesting(t)
+	w := postMention(s, "https://example.com/a", "http://www.bitworking.org/news/1/")
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	found := mentions["blog"].GetAll(context.Background(), "https://bitworking.org/news/1")
+	assert.Len(t, found, 1)
+	assert.Equal(t, "https://bitworking.org/news/1", found[0].Target)
+	key := found[0].Key()
+	assert.NoError(t, mentions["blog"].UpdateState(context.Background(), key, mention.GOOD_STATE))
+
+	r := httptest.NewRequest("GET", "/Mentions", nil)
+	r.Header.Set("Referer", "https://www.bitworking.org/news/1/#comments")
+	w = httptest.NewRecorder()
+	s.Mentions(w, r)
+	assert.Contains(t, w.Body.String(), "https://example.com/a")
+}
+
+func TestIncomingWebMentionUnknownTarget(t *testing.T) {
+	sitemap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>https://bitworking.org/news/1</loc></url></urlset>`))
+	}))
+	defer sitemap.Close()
+
+	s, mentions := newServerForTesting(t)
+	mentions["blog"].TargetChecker = mention.NewTargetChecker(sitemap.Client(), sitemap.URL, mentions["blog"].URLPolicy)
+
+	w := postMention(s, "https://example.com/a", "https://bitworking.org/news/1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = postMention(s, "https://example.com/a", "https://bitworking.org/news/2")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Target does not exist.")
+	assert.Len(t, mentions["blog"].GetAll(context.Background(), "https://bitworking.org/news/2"), 0)
+}
+
+func TestIncomingWebMentionAgainKeepsState(t *testing.T) {
+	s, mentions := newServerForTesting(t)
+	w := postMention(s, "https://example.com/a", "https://bitworking.org/news/1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	key := mention.New("https://example.com/a", "https://bitworking.org/news/1").Key()
+	assert.NoError(t, mentions["blog"].UpdateState(context.Background(), key, mention.GOOD_STATE))
+
+	w = postMention(s, "https://example.com/a", "https://bitworking.org/news/1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	m, err := mentions["blog"].Get(context.Background(), key)
+	assert.NoError(t, err)
+	assert.Equal(t, mention.GOOD_STATE, m.State)
+	assert.Len(t, mentions["blog"].GetQueued(context.Background()), 1)
+}
+
+func TestTargetClientTrustsNormalizedHosts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	assert.NoError(t, err)
+	u.Host = "localhost:" + u.Port()
+
+	// The TargetChecker requests localhost, the normalized form of
+	// www.localhost.
+	c := targetClient(&config.Site{Hosts: []string{"www.localhost"}}, mention.URLPolicy{})
+	resp, err := c.Get(u.String())
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// Unless www is kept.
+	c = targetClient(&config.Site{Hosts: []string{"www.localhost"}}, mention.URLPolicy{KeepWWW: true})
+	_, err = c.Get(u.String())
+	assert.Error(t, err)
+}